@@ -0,0 +1,188 @@
+package main
+
+// This file contains the subcommands, each of which parses its own flags and makes
+// one or more RPCs
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+// command runs a subcommand with the arguments that follow its name
+type command func(ctx context.Context, c pb.FordThunderbirdServiceClient, fs *flag.FlagSet, args []string, p printer) error
+
+var commands = map[string]command{
+	"ping":   ping,
+	"create": create,
+	"get":    get,
+	"update": update,
+	"delete": remove,
+	"list":   list,
+}
+
+// execute runs the subcommand called name
+func execute(ctx context.Context, c pb.FordThunderbirdServiceClient, name string, args []string, p printer, stderr io.Writer) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return &usageError{msg: fmt.Sprintf("unknown command %q", name)}
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return cmd(ctx, c, fs, args, p)
+}
+
+func ping(ctx context.Context, c pb.FordThunderbirdServiceClient, fs *flag.FlagSet, args []string, p printer) error {
+	data := fs.String("data", "ping", "data for the server to echo")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	r, err := c.Ping(ctx, &pb.PingRequest{Data: *data})
+	if err != nil {
+		return err
+	}
+	return p.ping(r)
+}
+
+func create(ctx context.Context, c pb.FordThunderbirdServiceClient, fs *flag.FlagSet, args []string, p printer) error {
+	name := fs.String("name", "", "name of the thunderbird")
+	requestID := fs.String("request-id", "", "idempotency key, retrying with the same key returns the first thunderbird")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if *name == "" {
+		return &usageError{msg: "create needs -name"}
+	}
+
+	r, err := c.CreateThunderbird(ctx, &pb.CreateThunderbirdRequest{Name: *name, RequestId: *requestID})
+	if err != nil {
+		return err
+	}
+	return p.thunderbird(r)
+}
+
+func get(ctx context.Context, c pb.FordThunderbirdServiceClient, fs *flag.FlagSet, args []string, p printer) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	r, err := c.GetThunderbird(ctx, &pb.ByIDRequest{Id: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	return p.thunderbird(r)
+}
+
+// update only changes the fields given as flags, sending them as the update mask
+func update(ctx context.Context, c pb.FordThunderbirdServiceClient, fs *flag.FlagSet, args []string, p printer) error {
+	name := fs.String("name", "", "new name of the thunderbird")
+	version := fs.Int64("version", 0, "version the thunderbird must still be at, 0 to skip the check")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	req := &pb.UpdateThunderbirdRequest{Id: fs.Arg(0), Name: *name, Version: *version}
+	mask := &fieldmaskpb.FieldMask{}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "name" {
+			mask.Paths = append(mask.Paths, "name")
+		}
+	})
+	if len(mask.Paths) == 0 {
+		return &usageError{msg: "update needs at least one field to change, e.g. -name"}
+	}
+	req.UpdateMask = mask
+
+	r, err := c.UpdateThunderbird(ctx, req)
+	if err != nil {
+		return err
+	}
+	return p.thunderbird(r)
+}
+
+func remove(ctx context.Context, c pb.FordThunderbirdServiceClient, fs *flag.FlagSet, args []string, p printer) error {
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	r, err := c.DeleteThunderbird(ctx, &pb.ByIDRequest{Id: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	return p.thunderbird(r)
+}
+
+// list prints one page, or with -all follows the page tokens and prints every page
+// as one list
+func list(ctx context.Context, c pb.FordThunderbirdServiceClient, fs *flag.FlagSet, args []string, p printer) error {
+	pageSize := fs.Int("page-size", 0, "thunderbirds per page, 0 for the server default")
+	pageToken := fs.String("page-token", "", "token of the page to start from")
+	prefix := fs.String("prefix", "", "only list names starting with prefix")
+	orderBy := fs.String("order-by", "name", "sort by name or created_at")
+	desc := fs.Bool("desc", false, "sort in descending order")
+	deleted := fs.Bool("include-deleted", false, "include soft deleted thunderbirds")
+	all := fs.Bool("all", false, "follow the page tokens and list every page")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	req := &pb.ListThunderbirdsRequest{
+		PageSize:       int32(*pageSize),
+		PageToken:      *pageToken,
+		NamePrefix:     *prefix,
+		Descending:     *desc,
+		IncludeDeleted: *deleted,
+	}
+	switch *orderBy {
+	case "name":
+		req.OrderBy = pb.ThunderbirdOrderBy_THUNDERBIRD_ORDER_BY_NAME
+	case "created_at":
+		req.OrderBy = pb.ThunderbirdOrderBy_THUNDERBIRD_ORDER_BY_CREATED_AT
+	default:
+		return &usageError{msg: fmt.Sprintf("unknown -order-by %q, must be name or created_at", *orderBy)}
+	}
+
+	r, err := c.ListThunderbirds(ctx, req)
+	if err != nil {
+		return err
+	}
+	for *all && r.GetNextPageToken() != "" {
+		req.PageToken = r.GetNextPageToken()
+		next, err := c.ListThunderbirds(ctx, req)
+		if err != nil {
+			return err
+		}
+		r.Thunderbirds = append(r.Thunderbirds, next.GetThunderbirds()...)
+		r.NextPageToken = next.GetNextPageToken()
+	}
+	return p.list(r)
+}
+
+// parse parses the flags in args, which may come before or after the n positional
+// arguments the command takes, e.g. both `update -name x ID` and `update ID -name x`
+func parse(fs *flag.FlagSet, args []string, n int) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return &usageError{msg: "help requested"}
+			}
+			return &usageError{msg: err.Error()}
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != n {
+		return &usageError{msg: fmt.Sprintf("%s takes %d argument(s), got %d", fs.Name(), n, len(positional))}
+	}
+	return fs.Parse(positional)
+}
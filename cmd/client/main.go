@@ -1,64 +1,210 @@
 package main
 
 import (
-    "context"
-    "log"
-    "os"
-    "time"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
 
-    "github.com/caring/ford-thunderbird/pb"
-    "google.golang.org/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-thunderbird/pb"
 )
 
+const usage = `usage: client [flags] <command> [command flags]
+
+commands:
+  ping                      check the server and its database
+  create -name N            create a thunderbird
+  get ID                    get a thunderbird
+  update ID [-name N]       update a thunderbird
+  delete ID                 soft delete a thunderbird
+  list                      list thunderbirds
+
+flags:`
+
+// exit codes, failed RPCs exit with rpcExitBase plus their grpc status code
 const (
-    defaultAddress = "localhost:"+envMust("PORT")
-    defaultData    = "00"
+	exitOK      = 0
+	exitError   = 1
+	exitUsage   = 2
+	rpcExitBase = 64
 )
 
+// options are the flags shared by every command
+type options struct {
+	address    string
+	useTLS     bool
+	caFile     string
+	skipVerify bool
+	timeout    time.Duration
+	headers    headerFlags
+	output     string
+}
+
+// headerFlags collects repeated -H "key: value" flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return errors.New(`headers must be in the form "key: value"`)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// pairs returns the headers as alternating keys and values for grpc metadata
+func (h headerFlags) pairs() []string {
+	kv := make([]string, 0, len(h)*2)
+	for _, v := range h {
+		parts := strings.SplitN(v, ":", 2)
+		kv = append(kv, strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]))
+	}
+	return kv
+}
+
+// usageError is a mistake in the command line rather than a failure to run it
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
 func main() {
-    data := defaultData
-    address := defaultAddress
-    if len(os.Args) > 2 {
-        address = os.Args[1]
-        data = os.Args[2]
-    }
-
-    conn, err := grpc.Dial(address, grpc.WithInsecure())
-    if err != nil {
-        log.Fatalf("did not connect: %v", err)
-    }
-    defer conn.Close()
-    c := pb.NewFordThunderbirdServiceClient(conn)
-
-    index := 0
-    for {
-        trip_time := time.Now()
-        ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-        defer cancel()
-        r, err := c.Ping(ctx, &pb.PingRequest{Data: data})
-        if err != nil {
-            log.Fatalf("could not connect to: %v", err)
-        }
-
-        log.Printf("%d characters roundtrip to (%s): seq=%d time=%s", len(data), address, index, time.Since(trip_time))
-        log.Print(r.Data)
-        time.Sleep(1 * time.Second)
-        index++
-    }
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
 }
 
-// fetches and returns the given env variable, fatals and
-// captures an exception if the variable is an empty string
-func envMust(varName string) string {
-	value := os.Getenv(varName)
-	if value == "" {
-		e := errors.New("environment variable missing - " + varName)
-		sentry.CaptureException(e)
-		if l != nil {
-			l.Fatal(e.Error())
-		} else {
-			log.Fatalln(e.Error())
+// run parses args, runs the command they name against the server and returns the
+// exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	opts := options{}
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.address, "addr", defaultAddress(), "server address as host:port, defaults to FORD_THUNDERBIRD_ADDR or localhost:$PORT")
+	fs.BoolVar(&opts.useTLS, "tls", false, "connect with TLS")
+	fs.StringVar(&opts.caFile, "cacert", "", "PEM file of the CAs to trust, instead of the system pool, implies -tls")
+	fs.BoolVar(&opts.skipVerify, "insecure-skip-verify", false, "do not verify the server certificate, implies -tls")
+	fs.DurationVar(&opts.timeout, "timeout", 10*time.Second, "deadline for each RPC")
+	fs.Var(&opts.headers, "H", `metadata header to send as "key: value", may be repeated`)
+	fs.StringVar(&opts.output, "o", "table", "output format, table or json")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
 		}
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
 	}
-	return value
-}
\ No newline at end of file
+
+	p, err := newPrinter(opts.output, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return exitUsage
+	}
+
+	conn, err := dial(opts)
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: could not connect:", err)
+		return exitError
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	if len(opts.headers) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, opts.headers.pairs()...)
+	}
+
+	err = execute(ctx, pb.NewFordThunderbirdServiceClient(conn), fs.Arg(0), fs.Args()[1:], p, stderr)
+	return report(err, stderr)
+}
+
+// defaultAddress is the server the client connects to without -addr
+func defaultAddress() string {
+	if addr := os.Getenv("FORD_THUNDERBIRD_ADDR"); addr != "" {
+		return addr
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		return "localhost:" + port
+	}
+	return "localhost:8080"
+}
+
+// dial connects to the server with the transport security from opts
+func dial(opts options) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if opts.useTLS || opts.caFile != "" || opts.skipVerify {
+		cfg := &tls.Config{InsecureSkipVerify: opts.skipVerify}
+		if opts.caFile != "" {
+			pem, err := os.ReadFile(opts.caFile)
+			if err != nil {
+				return nil, err
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, errors.New("no certificates found in " + opts.caFile)
+			}
+		}
+		creds = credentials.NewTLS(cfg)
+	}
+	return grpc.NewClient(opts.address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(rpcTimeout(opts.timeout)),
+	)
+}
+
+// rpcTimeout gives each unary RPC its own deadline of d, so a command that makes
+// several, like list -all, is not bounded by d as a whole
+func rpcTimeout(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// report prints err and returns the exit code for it. A failed RPC exits with
+// rpcExitBase plus its status code, so scripts can tell NOT_FOUND (69) from
+// UNAVAILABLE (78).
+func report(err error, stderr io.Writer) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(stderr, "Error:", usageErr.msg)
+		return exitUsage
+	}
+
+	if s, ok := status.FromError(err); ok && s.Code() != codes.OK {
+		fmt.Fprintf(stderr, "Error: %s: %s\n", s.Code(), s.Message())
+		return rpcExitBase + int(s.Code())
+	}
+
+	fmt.Fprintln(stderr, "Error:", err)
+	return exitError
+}
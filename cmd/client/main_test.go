@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+// fakeClient records the requests it is sent and answers with canned responses.
+// The embedded interface panics for any RPC the tests don't expect.
+type fakeClient struct {
+	pb.FordThunderbirdServiceClient
+
+	update *pb.UpdateThunderbirdRequest
+	lists  []*pb.ListThunderbirdsRequest
+	pages  []*pb.ListThunderbirdsResponse
+	err    error
+}
+
+func (f *fakeClient) GetThunderbird(ctx context.Context, in *pb.ByIDRequest, opts ...grpc.CallOption) (*pb.ThunderbirdResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &pb.ThunderbirdResponse{Id: in.GetId(), Name: "Thunderbird", Version: 1}, nil
+}
+
+func (f *fakeClient) UpdateThunderbird(ctx context.Context, in *pb.UpdateThunderbirdRequest, opts ...grpc.CallOption) (*pb.ThunderbirdResponse, error) {
+	f.update = in
+	return &pb.ThunderbirdResponse{Id: in.GetId(), Name: in.GetName(), Version: 2}, nil
+}
+
+func (f *fakeClient) ListThunderbirds(ctx context.Context, in *pb.ListThunderbirdsRequest, opts ...grpc.CallOption) (*pb.ListThunderbirdsResponse, error) {
+	f.lists = append(f.lists, &pb.ListThunderbirdsRequest{PageToken: in.GetPageToken(), OrderBy: in.GetOrderBy()})
+	r := f.pages[0]
+	f.pages = f.pages[1:]
+	return r, nil
+}
+
+// exec runs the command in args against c and returns its table output
+func exec(c *fakeClient, args ...string) (string, error) {
+	out := &bytes.Buffer{}
+	err := execute(context.Background(), c, args[0], args[1:], &tablePrinter{w: out}, io.Discard)
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	// ensures get prints the thunderbird as a table
+	t.Run("Get", func(t *testing.T) {
+		out, err := exec(&fakeClient{}, "get", "tb-1")
+		assert.NoError(t, err, "Expected no error")
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if assert.Len(t, lines, 2, "Expected a header and one row") {
+			assert.Equal(t, []string{"ID", "NAME", "VERSION", "CREATED", "DELETED"}, strings.Fields(lines[0]), "Expected the column headers")
+			assert.Equal(t, []string{"tb-1", "Thunderbird", "1", "-", "-"}, strings.Fields(lines[1]), "Expected the thunderbird")
+		}
+	})
+
+	// ensures positional arguments are checked
+	t.Run("Missing ID", func(t *testing.T) {
+		_, err := exec(&fakeClient{}, "get")
+		var usageErr *usageError
+		assert.True(t, errors.As(err, &usageErr), "Expected a usage error")
+	})
+
+	// ensures flags are accepted after the ID and only the given fields are masked
+	t.Run("Update", func(t *testing.T) {
+		c := &fakeClient{}
+		_, err := exec(c, "update", "tb-1", "-name", "Renamed", "-version", "1")
+		assert.NoError(t, err, "Expected no error")
+		if assert.NotNil(t, c.update, "Expected an update") {
+			assert.Equal(t, "tb-1", c.update.GetId(), "Expected the ID")
+			assert.Equal(t, "Renamed", c.update.GetName(), "Expected the name")
+			assert.Equal(t, int64(1), c.update.GetVersion(), "Expected the version")
+			assert.Equal(t, []string{"name"}, c.update.GetUpdateMask().GetPaths(), "Expected only the name in the mask")
+		}
+
+		_, err = exec(&fakeClient{}, "update", "tb-1", "-version", "1")
+		var usageErr *usageError
+		assert.True(t, errors.As(err, &usageErr), "Expected a usage error without any field to change")
+	})
+
+	// ensures list -all follows the page tokens
+	t.Run("List all", func(t *testing.T) {
+		c := &fakeClient{pages: []*pb.ListThunderbirdsResponse{
+			{Thunderbirds: []*pb.ThunderbirdResponse{{Id: "tb-1"}}, NextPageToken: "next"},
+			{Thunderbirds: []*pb.ThunderbirdResponse{{Id: "tb-2"}}},
+		}}
+		out, err := exec(c, "list", "-all", "-order-by", "created_at")
+		assert.NoError(t, err, "Expected no error")
+		if assert.Len(t, c.lists, 2, "Expected two pages") {
+			assert.Equal(t, "next", c.lists[1].GetPageToken(), "Expected the second page to be requested")
+			assert.Equal(t, pb.ThunderbirdOrderBy_THUNDERBIRD_ORDER_BY_CREATED_AT, c.lists[1].GetOrderBy(), "Expected the order to be kept")
+		}
+		assert.Len(t, strings.Split(strings.TrimSpace(out), "\n"), 3, "Expected a header and both rows")
+		assert.False(t, strings.Contains(out, "next page"), "Expected no page token after the last page")
+	})
+
+	// ensures unknown commands are rejected
+	t.Run("Unknown command", func(t *testing.T) {
+		_, err := exec(&fakeClient{}, "explode")
+		var usageErr *usageError
+		assert.True(t, errors.As(err, &usageErr), "Expected a usage error")
+	})
+}
+
+func TestReport(t *testing.T) {
+	// ensures each kind of error maps to its exit code
+	t.Run("Exit codes", func(t *testing.T) {
+		assert.Equal(t, exitOK, report(nil, io.Discard), "Expected success")
+		assert.Equal(t, exitUsage, report(&usageError{msg: "bad flag"}, io.Discard), "Expected a usage error")
+		assert.Equal(t, exitError, report(errors.New("no such file"), io.Discard), "Expected a local error")
+		assert.Equal(t, 69, report(status.Error(codes.NotFound, "missing"), io.Discard), "Expected NOT_FOUND to exit 69")
+		assert.Equal(t, 78, report(status.Error(codes.Unavailable, "down"), io.Discard), "Expected UNAVAILABLE to exit 78")
+	})
+
+	// ensures the status is printed for failed RPCs
+	t.Run("Message", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		_, err := exec(&fakeClient{err: status.Error(codes.NotFound, "thunderbird not found")}, "get", "tb-1")
+		code := report(err, out)
+		assert.Equal(t, rpcExitBase+int(codes.NotFound), code, "Expected the status code")
+		assert.Equal(t, "Error: NotFound: thunderbird not found\n", out.String(), "Expected the status")
+	})
+}
+
+func TestHeaders(t *testing.T) {
+	// ensures headers are split into lower cased metadata keys and values
+	t.Run("Pairs", func(t *testing.T) {
+		h := headerFlags{}
+		assert.NoError(t, h.Set("Authorization: Bearer abc"), "Expected no error")
+		assert.NoError(t, h.Set("x-request-id:42"), "Expected no error")
+		assert.Error(t, h.Set("novalue"), "Expected an error without a colon")
+		assert.Equal(t, []string{"authorization", "Bearer abc", "x-request-id", "42"}, h.pairs(), "Expected the pairs")
+	})
+}
+
+func TestRPCTimeout(t *testing.T) {
+	// ensures every RPC gets a deadline of its own rather than sharing one
+	t.Run("Per RPC", func(t *testing.T) {
+		deadlines := []time.Time{}
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			deadline, ok := ctx.Deadline()
+			assert.True(t, ok, "Expected a deadline")
+			deadlines = append(deadlines, deadline)
+			return nil
+		}
+
+		intercept := rpcTimeout(time.Hour)
+		assert.NoError(t, intercept(context.Background(), "/List", nil, nil, nil, invoker), "Expected no error")
+		time.Sleep(time.Millisecond)
+		assert.NoError(t, intercept(context.Background(), "/List", nil, nil, nil, invoker), "Expected no error")
+		if assert.Len(t, deadlines, 2, "Expected both RPCs to be invoked") {
+			assert.True(t, deadlines[1].After(deadlines[0]), "Expected the second RPC to get a fresh deadline")
+		}
+	})
+}
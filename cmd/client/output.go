@@ -0,0 +1,104 @@
+package main
+
+// This file contains the printers that write responses as a table or as JSON
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+// printer writes the responses of the commands in one output format
+type printer interface {
+	ping(r *pb.PingResponse) error
+	thunderbird(t *pb.ThunderbirdResponse) error
+	list(r *pb.ListThunderbirdsResponse) error
+}
+
+// newPrinter returns the printer for format, table or json
+func newPrinter(format string, w io.Writer) (printer, error) {
+	switch format {
+	case "table":
+		return &tablePrinter{w: w}, nil
+	case "json":
+		return &jsonPrinter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, must be table or json", format)
+}
+
+// tablePrinter writes responses as aligned columns for people to read
+type tablePrinter struct {
+	w io.Writer
+}
+
+func (p *tablePrinter) ping(r *pb.PingResponse) error {
+	_, err := fmt.Fprintln(p.w, r.GetData())
+	return err
+}
+
+func (p *tablePrinter) thunderbird(t *pb.ThunderbirdResponse) error {
+	return p.rows(t)
+}
+
+// list writes one row per thunderbird, followed by the token of the next page
+// when there is one
+func (p *tablePrinter) list(r *pb.ListThunderbirdsResponse) error {
+	if err := p.rows(r.GetThunderbirds()...); err != nil {
+		return err
+	}
+	if next := r.GetNextPageToken(); next != "" {
+		_, err := fmt.Fprintf(p.w, "\nnext page: %s\n", next)
+		return err
+	}
+	return nil
+}
+
+func (p *tablePrinter) rows(ts ...*pb.ThunderbirdResponse) error {
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tVERSION\tCREATED\tDELETED")
+	for _, t := range ts {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+			t.GetId(), t.GetName(), t.GetVersion(), formatTime(t.GetCreatedAt()), formatTime(t.GetDeletedAt()))
+	}
+	return tw.Flush()
+}
+
+// formatTime formats a timestamp for the table, or - when it isn't set
+func formatTime(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return "-"
+	}
+	return ts.AsTime().UTC().Format(time.RFC3339)
+}
+
+// jsonPrinter writes each response as protojson, for scripts
+type jsonPrinter struct {
+	w io.Writer
+}
+
+func (p *jsonPrinter) ping(r *pb.PingResponse) error {
+	return p.write(r)
+}
+
+func (p *jsonPrinter) thunderbird(t *pb.ThunderbirdResponse) error {
+	return p.write(t)
+}
+
+func (p *jsonPrinter) list(r *pb.ListThunderbirdsResponse) error {
+	return p.write(r)
+}
+
+func (p *jsonPrinter) write(m proto.Message) error {
+	b, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w, string(b))
+	return err
+}
@@ -2,22 +2,426 @@ package main
 
 // This file contains helpers to initialize application code that is specific to this service
 import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
 	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/internal/health"
+	"github.com/caring/ford-thunderbird/internal/metrics"
+	"github.com/caring/ford-thunderbird/internal/outbox"
+	"github.com/caring/ford-thunderbird/internal/purge"
+	"github.com/caring/ford-thunderbird/pb"
 	"github.com/caring/go-packages/pkg/logging"
+	"github.com/caring/go-packages/pkg/tracing"
 	"github.com/getsentry/sentry-go"
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// how often the outbox relay polls for new events
+	outboxRelayInterval = 1 * time.Second
+	// how often the outbox relay purges events past the change feed's retention
+	outboxPurgeInterval = 1 * time.Minute
+	// how many outbox events are published per tx
+	outboxRelayBatchSize = 100
+	// how often the purge job looks for expired soft deleted rows
+	purgeInterval = 1 * time.Hour
+	// how many soft deleted rows are purged per tx
+	purgeBatchSize = 100
+	// how often the health checker pings the database
+	healthCheckInterval = 5 * time.Second
+	// how long a health check ping may take before the database counts as down
+	healthCheckTimeout = 2 * time.Second
+	// the fully qualified name the grpc health service reports the api under
+	healthServiceName = "ford_thunderbird.FordThunderbirdService"
+	// how long in flight requests get to finish on shutdown before they are cut
+	// off, within the 30 seconds ECS waits after SIGTERM
+	shutdownTimeout = 20 * time.Second
 )
 
+// App holds the server's dependencies and runs it from startup to shutdown
+type App struct {
+	logger       *logging.Logger
+	tracer       *tracing.Tracer
+	dbConnection string
+	// the read replica's connection string, empty without a replica
+	dbReaderConnection string
+	store              db.Storage
+	relay              *outbox.Relay
+	purgeJob           *purge.Job
+	checker            *health.Checker
+	metrics            *metrics.Metrics
+	grpc               *grpc.Server
+
+	// set by Serve and read by Shutdown
+	mu      sync.Mutex
+	closing bool
+	http    *http.Server
+	mux     cmux.CMux
+	gateway *grpc.ClientConn
+	cancel  context.CancelFunc
+	// jobs tracks the background goroutines so the store outlives them
+	jobs sync.WaitGroup
+}
+
+// NewApp sets up logging and error reporting and reads the db connection from env
+func NewApp() *App {
+	l = initLogger()
+	initSentry(l)
+
+	return &App{
+		logger:             l,
+		dbConnection:       setDBConnectionString(l),
+		dbReaderConnection: setDBReaderConnectionString(l),
+	}
+}
+
+// Migrate runs the migrate subcommand given by args
+func (a *App) Migrate(args []string) error {
+	return runMigrate(a.logger, a.dbConnection, args)
+}
+
+// Init initializes every dependency of the server. Migrations are not applied,
+// run `main migrate up` before starting a server on a newer schema.
+func (a *App) Init() {
+	store := initStore(a.logger, a.dbConnection, a.dbReaderConnection)
+	a.store = db.NewStorage(store)
+	a.metrics = initMetrics(a.logger, store)
+	initQueryTracing(a.logger, store)
+	a.relay = initOutboxRelay(a.logger, store)
+	a.purgeJob = initPurgeJob(a.logger, store)
+	a.checker = initHealthChecker(a.logger, a.store)
+
+	a.tracer = initTracing(a.logger)
+	a.grpc = createGRPCServer(a.logger, a.tracer, a.metrics)
+}
+
+// Serve multiplexes grpc and http/1 on lis and runs the background jobs. It
+// blocks until Shutdown is called, returning nil, or one of the protocols fails,
+// returning its error.
+func (a *App) Serve(lis net.Listener) error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// create a cmux
+	m := cmux.New(lis)
+	// match connections in order:
+	// first grpc, then http.
+	grpcL := m.Match(cmux.HTTP2())
+	httpL := m.Match(cmux.HTTP1Fast())
+
+	// register the server with gRPC
+	pb.RegisterFordThunderbirdServiceServer(a.grpc, &service{
+		logger:   a.logger,
+		store:    a.store,
+		checker:  a.checker,
+		shutdown: ctx,
+	})
+	healthpb.RegisterHealthServer(a.grpc, a.checker.Server())
+
+	// Add health check endpoints for automated container monitoring. /health is
+	// kept as an alias of liveness for existing container checks.
+	mux := http.NewServeMux()
+	mux.Handle("/health", health.LiveHandler())
+	mux.Handle("/health/live", health.LiveHandler())
+	mux.Handle("/health/ready", a.checker.ReadyHandler())
+	if a.metrics != nil {
+		mux.Handle("/metrics", a.metrics.Handler())
+	}
+
+	// serve the api as JSON to clients that cannot speak grpc
+	gateway, conn, err := createGateway(ctx, lis.Addr().String())
+	if err != nil {
+		cancel()
+		return err
+	}
+	mux.Handle(gatewayPrefix, gateway)
+	srv := &http.Server{Handler: mux}
+
+	a.mu.Lock()
+	if a.closing {
+		a.mu.Unlock()
+		conn.Close()
+		cancel()
+		return nil
+	}
+	a.http, a.mux, a.gateway, a.cancel = srv, m, conn, cancel
+	// start the background jobs under mu so Shutdown cannot wait on them before
+	// they are tracked
+	a.runJobs(ctx)
+	a.mu.Unlock()
+
+	// make an error channel to collect the exits of each protocol's Serve()
+	eChan := make(chan error, 3)
+
+	// start listeners for each protocol
+	go func() { eChan <- a.grpc.Serve(grpcL) }()
+	go func() { eChan <- srv.Serve(httpL) }()
+	go func() { eChan <- m.Serve() }()
+
+	// all systems are a go
+	a.logger.Info("server started: multiplexed http/1, http/2",
+		logging.String("address", lis.Addr().String()),
+		logging.String("multiplexed", "true"),
+	)
+
+	// the protocols only exit on their own when something is wrong, or when
+	// Shutdown closes them
+	err = <-eChan
+
+	a.mu.Lock()
+	closing := a.closing
+	a.mu.Unlock()
+	if closing {
+		return nil
+	}
+	return err
+}
+
+// runJobs starts the background jobs, each tracked by jobs until ctx ends it
+func (a *App) runJobs(ctx context.Context) {
+	run := func(job func(ctx context.Context)) {
+		a.jobs.Add(1)
+		go func() {
+			defer a.jobs.Done()
+			job(ctx)
+		}()
+	}
+
+	// report the database's availability to health checks in the background
+	run(a.checker.Run)
+
+	// publish outbox events and purge the outbox in the background
+	if a.relay != nil {
+		run(a.relay.Run)
+	}
+
+	// permanently remove expired soft deleted thunderbirds in the background
+	if a.purgeJob != nil {
+		run(a.purgeJob.Run)
+	}
+}
+
+// Shutdown stops the server in order: health checks report NOT_SERVING, the
+// background jobs and watch streams stop, in flight RPCs finish or are cut off at
+// ctx's deadline, the http server, the REST gateway's connection and the listener
+// close, the background jobs are waited on until ctx's deadline, then the store,
+// tracer, Sentry and logger are closed and flushed. It returns every error hit
+// along the way.
+func (a *App) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.closing = true
+	srv, m, conn, cancel := a.http, a.mux, a.gateway, a.cancel
+	a.mu.Unlock()
+
+	a.logger.Info("Shutting down")
+	var errs []error
+
+	// stop routing new requests here before refusing them
+	if a.checker != nil {
+		a.checker.Shutdown()
+	}
+
+	// watch streams only end with their ctx, so end them before waiting on RPCs
+	if cancel != nil {
+		cancel()
+	}
+
+	if a.grpc != nil {
+		stopped := make(chan struct{})
+		go func() {
+			a.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			a.logger.Warn("Timed out waiting for in flight RPCs, stopping")
+			a.grpc.Stop()
+			<-stopped
+		}
+	}
+
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+	if m != nil {
+		m.Close()
+	}
+
+	// let the jobs finish with the store before it closes
+	jobsDone := make(chan struct{})
+	go func() {
+		a.jobs.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		a.logger.Warn("Timed out waiting for background jobs, closing the store")
+		errs = append(errs, errors.New("background jobs did not stop before the shutdown deadline"))
+	}
+
+	if a.store != nil {
+		if err := a.store.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if a.tracer != nil {
+		if err := a.tracer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		sentry.CaptureException(err)
+		a.logger.Error("Error shutting down:" + err.Error())
+	} else {
+		a.logger.Info("Shut down")
+	}
+
+	sentry.Flush(5 * time.Second)
+	a.logger.Sync()
+	a.logger.Close()
+	return err
+}
 
 // initialize the store service
-func initStore(logger *logging.Logger, connectionString string) *db.Store {
+func initStore(logger *logging.Logger, connectionString, readerConnectionString string) *db.Store {
 	logger.Debug("Initializing Store")
-	// establish a store and connection to the db
-	store, err := db.NewStore(connectionString)
-	if err != nil {
+	// establish a store and connection to the db, and to the read replica if any
+	store, err := db.NewStore(connectionString, readerConnectionString)
+	var prepErr *db.PrepareError
+	if errors.As(err, &prepErr) {
+		// report every statement that failed to prepare, those that are not
+		// required are prepared again when next used
+		for _, f := range prepErr.Failures {
+			logger.Error("Failed to prepare statement",
+				logging.String("statement", f.Name),
+				logging.Bool("replica", f.Replica),
+				logging.Bool("required", f.Required),
+				logging.Error(f.Err),
+			)
+		}
+		if prepErr.ReplicaErr != nil {
+			logger.Warn("Read replica unreachable, reading from the primary until it is",
+				logging.Error(prepErr.ReplicaErr),
+			)
+		}
+		sentry.CaptureException(err)
+		if prepErr.Required() {
+			store.Close()
+			logger.Fatal("Failed to initialize store:" + err.Error())
+		}
+	} else if err != nil {
 		sentry.CaptureException(err)
 		logger.Fatal("Failed to initialize store:" + err.Error())
 	}
 	logger.Debug("Store established with database connection")
+
+	reprepare, err := strconv.ParseBool(envMust("DB_LAZY_REPREPARE"))
+	if err != nil {
+		logger.Fatal("Error getting DB_LAZY_REPREPARE variable")
+	}
+	if reprepare {
+		store.EnableReprepare()
+	}
 	return store
 }
 
+// initialize the relay that publishes outbox events to the reporting firehose
+// and purges them once the change feed no longer needs them. If publishing is
+// disabled the relay still runs, discarding events, so the outbox is purged.
+func initOutboxRelay(logger *logging.Logger, store *db.Store) *outbox.Relay {
+	logger.Debug("Initializing Outbox Relay")
+	disabled, err := strconv.ParseBool(envMust("OUTBOX_DISABLE"))
+	if err != nil {
+		logger.Fatal("Error getting OUTBOX_DISABLE variable")
+	}
+
+	var publisher outbox.Publisher = outbox.DiscardPublisher{}
+	if disabled {
+		logger.Debug("Publishing disabled, discarding events")
+	} else {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			sentry.CaptureException(err)
+			logger.Fatal("Failed to load AWS config:" + err.Error())
+		}
+		publisher = outbox.NewFirehosePublisher(firehose.NewFromConfig(cfg), envMust("OUTBOX_STREAM"))
+	}
+
+	logger.Debug("Done")
+	return outbox.NewRelay(store.Outbox, publisher, logger, outboxRelayInterval, outboxPurgeInterval, outboxRelayBatchSize)
+}
+
+// initialize the job that permanently removes thunderbirds soft deleted for longer
+// than PURGE_RETENTION and expired idempotency keys, returns nil if the job is disabled
+func initPurgeJob(logger *logging.Logger, store *db.Store) *purge.Job {
+	logger.Debug("Initializing Purge Job")
+	disabled, err := strconv.ParseBool(envMust("PURGE_DISABLE"))
+	if err != nil {
+		logger.Fatal("Error getting PURGE_DISABLE variable")
+	}
+	if disabled {
+		logger.Debug("Skipping")
+		return nil
+	}
+
+	retention, err := time.ParseDuration(envMust("PURGE_RETENTION"))
+	if err != nil || retention <= 0 {
+		logger.Fatal("Error getting PURGE_RETENTION variable")
+	}
+
+	logger.Debug("Done")
+	return purge.NewJob(store.Thunderbird, logger, retention, purgeInterval, purgeBatchSize)
+}
+
+// initialize the prometheus metrics and instrument the store's statements and
+// connection pool with them
+func initMetrics(logger *logging.Logger, store *db.Store) *metrics.Metrics {
+	logger.Debug("Initializing Metrics")
+	m := metrics.New()
+	store.AddQueryHook(m.QueryHook())
+	store.AddRetryHook(m.RetryHook())
+	m.RegisterDBStats(store.Stats)
+	logger.Debug("Done")
+	return m
+}
+
+// wrap the store's statements in tracing spans and log those slower than
+// DB_SLOW_QUERY_THRESHOLD
+func initQueryTracing(logger *logging.Logger, store *db.Store) {
+	logger.Debug("Initializing Query Tracing")
+	threshold, err := time.ParseDuration(envMust("DB_SLOW_QUERY_THRESHOLD"))
+	if err != nil || threshold < 0 {
+		logger.Fatal("Error getting DB_SLOW_QUERY_THRESHOLD variable")
+	}
+
+	store.AddQueryHook(db.SpanHook())
+	store.AddQueryHook(db.SlowQueryHook(logger, threshold))
+	logger.Debug("Done")
+}
+
+// initialize the checker that reports the database's availability through the
+// grpc health service and the readiness endpoint
+func initHealthChecker(logger *logging.Logger, store db.Storage) *health.Checker {
+	logger.Debug("Initializing Health Checker")
+	checker := health.NewChecker(store, logger, healthCheckInterval, healthCheckTimeout, healthServiceName)
+	logger.Debug("Done")
+	return checker
+}
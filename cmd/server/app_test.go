@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/internal/health"
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// newTestApp creates an App backed by an in memory store, with none of the
+// dependencies that need env config or AWS
+func newTestApp() *App {
+	logger := &logging.Logger{}
+	store := db.NewMemoryStore()
+	return &App{
+		logger:  logger,
+		store:   store,
+		checker: health.NewChecker(store, logger, time.Hour, time.Second, healthServiceName),
+		grpc:    grpc.NewServer(),
+	}
+}
+
+func TestApp(t *testing.T) {
+	// ensures grpc and http are multiplexed on one listener and shut down in order
+	t.Run("Serve and shutdown", func(t *testing.T) {
+		a := newTestApp()
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "listener setup failed")
+		}
+		addr := lis.Addr().String()
+
+		served := make(chan error, 1)
+		go func() { served <- a.Serve(lis) }()
+
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "client setup failed")
+		}
+		defer conn.Close()
+		client := healthpb.NewHealthClient(conn)
+
+		assert.Eventually(t, func() bool {
+			r, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: healthServiceName})
+			return err == nil && r.GetStatus() == healthpb.HealthCheckResponse_SERVING
+		}, 5*time.Second, 10*time.Millisecond, "Expected the service to be serving over grpc")
+
+		r, err := http.Get("http://" + addr + "/health/ready")
+		if assert.NoError(t, err, "Expected no error") {
+			r.Body.Close()
+			assert.Equal(t, http.StatusOK, r.StatusCode, "Expected ready over http")
+		}
+
+		r, err = http.Get("http://" + addr + "/v1/unknown")
+		if assert.NoError(t, err, "Expected no error") {
+			r.Body.Close()
+			assert.Equal(t, http.StatusNotFound, r.StatusCode, "Expected the gateway to reject unknown routes")
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"), "Expected a JSON error from the gateway")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		assert.NoError(t, a.Shutdown(ctx), "Expected a clean shutdown")
+
+		select {
+		case err = <-served:
+			assert.NoError(t, err, "Expected serve to return without error on shutdown")
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Expected serve to return on shutdown")
+		}
+		assert.Error(t, a.checker.Err(), "Expected readiness to fail after shutdown")
+	})
+
+	// ensures the store is only closed once the background jobs have stopped, or
+	// the shutdown deadline has passed
+	t.Run("Waits for jobs", func(t *testing.T) {
+		a := newTestApp()
+		var finished atomic.Bool
+		a.jobs.Add(1)
+		go func() {
+			defer a.jobs.Done()
+			time.Sleep(50 * time.Millisecond)
+			finished.Store(true)
+		}()
+		assert.NoError(t, a.Shutdown(context.Background()), "Expected a clean shutdown")
+		assert.True(t, finished.Load(), "Expected shutdown to wait for the job")
+
+		a = newTestApp()
+		a.jobs.Add(1)
+		defer a.jobs.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Error(t, a.Shutdown(ctx), "Expected a job that does not stop to be reported")
+	})
+
+	// ensures shutting down before serving makes serve return straight away
+	t.Run("Shutdown before serve", func(t *testing.T) {
+		a := newTestApp()
+		assert.NoError(t, a.Shutdown(context.Background()), "Expected a clean shutdown")
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "listener setup failed")
+		}
+		defer lis.Close()
+		assert.NoError(t, a.Serve(lis), "Expected serve to return straight away")
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+// This file contains the REST gateway that serves the grpc api as JSON over HTTP/1
+import (
+	"context"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+// gatewayPrefix is the path every route of the REST gateway starts with
+const gatewayPrefix = "/v1/"
+
+// create the REST gateway, which transcodes each request into an RPC on the grpc
+// server at addr. Going through the server rather than calling the service directly
+// runs the same interceptors as native grpc clients, and supports streaming. Error
+// statuses are mapped to their HTTP equivalents, e.g. NOT_FOUND is served as 404.
+func createGateway(ctx context.Context, addr string) (http.Handler, *grpc.ClientConn, error) {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mux := runtime.NewServeMux()
+	if err = pb.RegisterFordThunderbirdServiceHandler(ctx, mux, conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return mux, conn, nil
+}
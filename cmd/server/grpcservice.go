@@ -1,29 +1,80 @@
 package main
 
 import (
-  _ "context"
+	"context"
 
-  _ "github.com/caring/ford-thunderbird/internal/db"
-
-  _ "github.com/caring/ford-thunderbird/internal/handlers"
-  "github.com/caring/ford-thunderbird/pb"
-  _ "github.com/caring/go-packages/pkg/errors"
-  _ "google.golang.org/grpc/codes"
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/internal/handlers"
+	"github.com/caring/ford-thunderbird/internal/health"
+	"github.com/caring/ford-thunderbird/pb"
+	"github.com/caring/go-packages/pkg/logging"
 )
 
 type service struct {
+	logger  *logging.Logger
+	store   db.Storage
+	checker *health.Checker
+	// shutdown is cancelled when the server starts shutting down, ending the
+	// streams that would otherwise hold up a graceful stop
+	shutdown context.Context
 }
 
 func (s *service) Ping(ctx context.Context, in *pb.PingRequest) (*pb.PingResponse, error) {
-    l.Printf("Received: %v", in.Data)
-    resp := "Data: " + in.Data
-    
-    ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
-    defer cancel()
-    status := "up"
-    if err := store.Ping(ctx); err != nil {
-      status = "down"
-    }
-    return &pb.PingResponse{Data: resp + "; Database: " + status}, nil
-    
-}
\ No newline at end of file
+	s.logger.Info("Received: " + in.Data)
+	resp := "Data: " + in.Data
+
+	// report the latest background check instead of pinging on every request
+	status := "up"
+	if err := s.checker.Err(); err != nil {
+		status = "down"
+	}
+	return &pb.PingResponse{Data: resp + "; Database: " + status}, nil
+}
+
+func (s *service) CreateThunderbird(ctx context.Context, in *pb.CreateThunderbirdRequest) (*pb.ThunderbirdResponse, error) {
+	return handlers.CreateThunderbird(ctx, in, s.store.Thunderbirds())
+}
+
+func (s *service) GetThunderbird(ctx context.Context, in *pb.ByIDRequest) (*pb.ThunderbirdResponse, error) {
+	return handlers.GetThunderbird(ctx, in, s.store.Thunderbirds())
+}
+
+func (s *service) UpdateThunderbird(ctx context.Context, in *pb.UpdateThunderbirdRequest) (*pb.ThunderbirdResponse, error) {
+	return handlers.UpdateThunderbird(ctx, in, s.store.Thunderbirds())
+}
+
+func (s *service) DeleteThunderbird(ctx context.Context, in *pb.ByIDRequest) (*pb.ThunderbirdResponse, error) {
+	return handlers.DeleteThunderbird(ctx, in, s.store)
+}
+
+func (s *service) UndeleteThunderbird(ctx context.Context, in *pb.ByIDRequest) (*pb.ThunderbirdResponse, error) {
+	return handlers.UndeleteThunderbird(ctx, in, s.store.Thunderbirds())
+}
+
+func (s *service) ListThunderbirds(ctx context.Context, in *pb.ListThunderbirdsRequest) (*pb.ListThunderbirdsResponse, error) {
+	return handlers.ListThunderbirds(ctx, in, s.store.Thunderbirds())
+}
+
+func (s *service) LoadThunderbirds(ctx context.Context, in *pb.LoadKeyRequest) (*pb.LoadThunderbirdsResponse, error) {
+	return handlers.LoadThunderbirds(ctx, in, s.store.Thunderbirds())
+}
+
+func (s *service) WatchThunderbirds(in *pb.WatchThunderbirdsRequest, stream pb.FordThunderbirdService_WatchThunderbirdsServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	if s.shutdown != nil {
+		stop := context.AfterFunc(s.shutdown, cancel)
+		defer stop()
+	}
+	return handlers.WatchThunderbirds(in, &watchStream{stream, ctx}, s.store)
+}
+
+// watchStream is a watch stream with a ctx that also ends on shutdown
+type watchStream struct {
+	pb.FordThunderbirdService_WatchThunderbirdsServer
+	ctx context.Context
+}
+
+func (w *watchStream) Context() context.Context {
+	return w.ctx
+}
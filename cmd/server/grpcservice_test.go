@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// openWatchStream is a watch stream whose client never disconnects
+type openWatchStream struct {
+	grpc.ServerStream
+}
+
+func (s *openWatchStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *openWatchStream) Send(*pb.ThunderbirdEvent) error {
+	return nil
+}
+
+func TestService_WatchThunderbirds(t *testing.T) {
+	// ensures an open watch ends on shutdown rather than holding up a graceful stop
+	t.Run("Shutdown", func(t *testing.T) {
+		shutdown, cancel := context.WithCancel(context.Background())
+		s := &service{store: db.NewMemoryStore(), shutdown: shutdown}
+
+		done := make(chan error, 1)
+		go func() { done <- s.WatchThunderbirds(&pb.WatchThunderbirdsRequest{}, &openWatchStream{}) }()
+		cancel()
+
+		select {
+		case err := <-done:
+			assert.NoError(t, err, "Expected the watch to end cleanly")
+		case <-time.After(time.Second):
+			assert.Fail(t, "Expected the watch to end on shutdown")
+		}
+	})
+}
@@ -1,58 +1,37 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"log"
 	"net"
-	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
-	"log"
-
-
-	"github.com/caring/ford-thunderbird/internal/db"
 
-	"github.com/caring/ford-thunderbird/pb"
 	"github.com/caring/go-packages/pkg/logging"
-	"github.com/caring/go-packages/pkg/tracing"
 	"github.com/getsentry/sentry-go"
-
-	"github.com/soheilhy/cmux"
-	"google.golang.org/grpc"
-)
-
-
-var (
-	store        *db.Store
-	dbConnection string
-)
-
-
-var (
-	l *logging.Logger
-	t *tracing.Tracer
-)
-
-var (
-	g *grpc.Server
 )
 
-func init() {
-	l = initLogger()
-	initSentry(l)
+// l is the logger envMust reports missing variables to, set by NewApp
+var l *logging.Logger
 
-	dbConnection = setDBConnectionString(l)
-	migrateDatabase(l, dbConnection)
-	store = initStore(l, dbConnection)
+func main() {
+	app := NewApp()
 
-	t = initTracing(l)
-	g = createGRPCServer(l, t)
-}
+	// `main migrate <command>` manages the schema and exits without serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := app.Migrate(os.Args[2:])
+		sentry.Flush(5 * time.Second)
+		l.Sync()
+		if err != nil {
+			l.Fatal("Migrate failed: " + err.Error())
+		}
+		return
+	}
 
-func main() {
-	defer sentry.Flush(5 * time.Second)
-	defer t.Close()
-	defer l.Sync()
-	defer l.Close()
+	app.Init()
 
 	// main listener
 	lis, err := net.Listen("tcp", ":"+envMust("PORT"))
@@ -61,44 +40,30 @@ func main() {
 		l.Fatal("Failed to initialize net listener:" + err.Error())
 	}
 
-	// create a cmux
-	m := cmux.New(lis)
-	// match connections in order:
-	// first grpc, then http.
-	grpcL := m.Match(cmux.HTTP2())
-	httpL := m.Match(cmux.HTTP1Fast())
-
-	// register the server with gRPC
-	pb.RegisterFordThunderbirdServer(g, &service{})
-
-	// Add a health check endpoint for automated container monitoring
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
-	// make an error channel to collect the exits of each protocol's Serve()
-	eChan := make(chan error)
-
-	// start listeners for each protocol
-	go func() { eChan <- g.Serve(grpcL) }()
-	go func() { eChan <- http.Serve(httpL, nil) }()
-
-	// all systems are a go
-	l.Info("server started: multiplexed http/1, http/2",
-		logging.String("port", envMust("PORT")),
-		logging.String("multiplexed", "true"),
-	)
+	// ECS sends SIGTERM to stop a task, SIGINT covers ctrl-c when run locally
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	// serve it up
-	go func() { eChan <- m.Serve() }()
+	eChan := make(chan error, 1)
+	go func() { eChan <- app.Serve(lis) }()
 
-	for err := range eChan {
+	select {
+	case <-ctx.Done():
+		l.Info("Received shutdown signal")
+	case err = <-eChan:
 		if err != nil {
 			sentry.CaptureException(err)
 			l.Error("Error from one of the HTTP protocols:" + err.Error())
 		}
 	}
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = app.Shutdown(shutdownCtx); err != nil {
+		log.Println("Error shutting down:", err.Error())
+		os.Exit(1)
+	}
 }
 
 // fetches and returns the given env variable, fatals and
@@ -0,0 +1,119 @@
+package main
+
+// This file contains the migrate subcommand
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+const migrateUsage = `usage: main migrate <command>
+
+commands:
+  up        apply every pending migration
+  down N    roll back the last N migrations
+  goto V    migrate up or down to version V
+  force V   set the version to V and clear the dirty flag without running anything
+  status    print the current version and dirty flag`
+
+// create a migrate instance for the database. Migrations are read from the binary
+// unless DB_MIGRATIONS_SRC overrides them with a golang-migrate source url.
+func newMigrate(connectionString string) (*migrate.Migrate, error) {
+	if src := os.Getenv("DB_MIGRATIONS_SRC"); src != "" {
+		return migrate.New(src, "mysql://"+connectionString)
+	}
+
+	src, err := iofs.New(db.Migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.NewWithSourceInstance("iofs", src, "mysql://"+connectionString)
+}
+
+// runs the migrate subcommand given by args and reports the resulting version
+func runMigrate(logger *logging.Logger, connectionString string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	// validate the arguments before connecting
+	var (
+		arg int
+		err error
+	)
+	switch args[0] {
+	case "up", "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+	case "down", "goto", "force":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		if arg, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("%s expects a number, got %q", args[0], args[1])
+		}
+		if args[0] == "down" && arg < 1 {
+			return errors.New("down expects a positive number of migrations")
+		}
+		if args[0] == "goto" && arg < 0 {
+			return errors.New("goto expects a non negative version")
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	// migrations run against the connection's schema, which a fresh environment
+	// may not have yet
+	if args[0] == "up" || args[0] == "goto" {
+		if err = db.EnsureSchema(connectionString); err != nil {
+			return err
+		}
+	}
+
+	m, err := newMigrate(connectionString)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		logger.Info("Running migrations up")
+		err = m.Up()
+	case "down":
+		logger.Info("Rolling back migrations", logging.Int("steps", arg))
+		err = m.Steps(-arg)
+	case "goto":
+		logger.Info("Migrating to version", logging.Int("version", arg))
+		err = m.Migrate(uint(arg))
+	case "force":
+		logger.Info("Forcing migration version", logging.Int("version", arg))
+		err = m.Force(arg)
+	}
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	version, dirty, err := m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		logger.Info("No migrations applied")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger.Info("Current migration version",
+		logging.Int64("version", int64(version)),
+		logging.Bool("dirty", dirty),
+	)
+	return nil
+}
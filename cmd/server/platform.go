@@ -2,20 +2,17 @@ package main
 
 // This file contains helpers that initialize app insight, developer tooling and database set up that might be run on any given app
 import (
-	"fmt"
 	"log"
+	"os"
 	"strconv"
 
+	"github.com/caring/ford-thunderbird/internal/metrics"
 	"github.com/caring/go-packages/pkg/grpc_middleware"
 	"github.com/caring/go-packages/pkg/logging"
 	"github.com/caring/go-packages/pkg/tracing"
 	"github.com/getsentry/sentry-go"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/mysql"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/golang-migrate/migrate/v4/source/github"
 
 	"google.golang.org/grpc"
 )
@@ -73,7 +70,7 @@ func initTracing(logger *logging.Logger) *tracing.Tracer {
 }
 
 // create protocol server with chained interceptors
-func createGRPCServer(logger *logging.Logger, tracer *tracing.Tracer) *grpc.Server {
+func createGRPCServer(logger *logging.Logger, tracer *tracing.Tracer, m *metrics.Metrics) *grpc.Server {
 	return grpc.NewServer(
 		grpc_middleware.NewGRPCChainedUnaryInterceptor(grpc_middleware.UnaryOptions{
 			Logger: logger,
@@ -83,10 +80,12 @@ func createGRPCServer(logger *logging.Logger, tracer *tracing.Tracer) *grpc.Serv
 			Logger: logger,
 			Tracer: tracer,
 		}),
+		// chained after the middleware's interceptors
+		grpc.ChainUnaryInterceptor(m.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(m.StreamServerInterceptor()),
 	)
 }
 
-
 // create the db connection string from env
 func setDBConnectionString(logger *logging.Logger) string {
 	logger.Debug("Creating DB connection string")
@@ -96,36 +95,24 @@ func setDBConnectionString(logger *logging.Logger) string {
 	port := envMust("DB_PORT")
 	schema := envMust("DB_SCHEMA")
 	logger.Debug("Done")
-	return user + ":" + pwd + "@tcp(" + host + ":" + port + ")/" + schema
+	return dbConnectionString(user, pwd, host, port, schema)
 }
 
-// perform the database migration from env config
-func migrateDatabase(logger *logging.Logger, connectionString string) {
-	logger.Info("Connecting to DB")
-
-	m, err := migrate.New(
-		envMust("DB_MIGRATIONS_SRC"),
-		"mysql://"+connectionString,
-	)
-	if err != nil {
-		sentry.CaptureException(err)
-		logger.Fatal("Failure running migrations to update database:" + err.Error())
-	}
-	logger.Info("Running migration")
-	err = m.Up()
-	if err != nil {
-		if err != migrate.ErrNoChange {
-			sentry.CaptureException(err)
-			logger.Fatal("Migrations Failed: " + err.Error())
-		}
-	}
-	version, dirty, mErr := m.Version()
-	logger.Info(fmt.Sprint("Current migration version: ", version))
-	logger.Info(fmt.Sprint("Migration dirty: ", dirty))
-	if mErr != nil {
-		sentry.CaptureException(err)
-		logger.Fatal("Migration error: " + mErr.Error())
+// create the connection string of the read replica from env, which has the same
+// credentials and schema as the primary. Returns "" to send every read to the
+// primary when DB_READER_HOST is not set.
+func setDBReaderConnectionString(logger *logging.Logger) string {
+	logger.Debug("Creating DB reader connection string")
+	host := os.Getenv("DB_READER_HOST")
+	if host == "" {
+		logger.Debug("Skipping")
+		return ""
 	}
 	logger.Debug("Done")
+	return dbConnectionString(envMust("DB_USER"), envMust("DB_PWD"), host, envMust("DB_PORT"), envMust("DB_SCHEMA"))
 }
 
+func dbConnectionString(user, pwd, host, port, schema string) string {
+	// parseTime scans DATETIME columns into time.Time
+	return user + ":" + pwd + "@tcp(" + host + ":" + port + ")/" + schema + "?parseTime=true"
+}
@@ -0,0 +1,447 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+)
+
+// ErrChangesExpired occurs when a resume token is malformed or points before the
+// oldest change still retained
+var ErrChangesExpired = errors.New("changes after the resume token are no longer available")
+
+const (
+	// changeRetention is how long a change can be resumed from. The outbox relay
+	// removes events published longer ago than this.
+	changeRetention = 24 * time.Hour
+	// changeSettle is how long the Store's feed waits before it delivers the changes
+	// up to an event_id it has seen. A tx that wrote an event with a lower ID and is
+	// still open then has that long to commit; one that commits later is never
+	// delivered.
+	changeSettle = 5 * time.Second
+	// changeBatchSize is the number of changes read from a source per query
+	changeBatchSize = 100
+)
+
+// ChangeType is the kind of mutation a Change records
+type ChangeType int
+
+const (
+	// ChangeCreated is recorded when a thunderbird is created
+	ChangeCreated ChangeType = iota + 1
+	// ChangeUpdated is recorded when a thunderbird is updated
+	ChangeUpdated
+	// ChangeDeleted is recorded when a thunderbird is soft deleted
+	ChangeDeleted
+	// ChangeRestored is recorded when a soft deleted thunderbird is restored
+	ChangeRestored
+	// ChangePurged is recorded when a soft deleted thunderbird is permanently removed
+	ChangePurged
+)
+
+// Change is a committed thunderbird mutation
+type Change struct {
+	Type ChangeType
+	// Thunderbird holds the values written by the mutation. For deletes, restores
+	// and purges only ID is set.
+	Thunderbird *Thunderbird
+	At          time.Time
+	// Token resumes a subscription immediately after this change
+	Token string
+
+	// id orders changes within their source, for the outbox it is the event_id
+	id int64
+}
+
+// ChangeSource is a durable log of committed changes ordered by ID, that a
+// ChangeFeed reads from
+type ChangeSource interface {
+	// LastChangeID returns the ID of the newest change, or 0 when there are none
+	LastChangeID(ctx context.Context) (int64, error)
+	// ChangesAfter returns up to limit of the changes with an ID greater than after,
+	// in ID order
+	ChangesAfter(ctx context.Context, after int64, limit int) ([]Change, error)
+}
+
+// ChangeFeed streams the changes in a ChangeSource to subscribers. It reads the
+// source on a single poller shared by every subscription, so it sees every change
+// committed by any process sharing the source, and its resume tokens hold the ID
+// of a change rather than any state of the process that issued them.
+//
+// Change IDs are not contiguous, an ID is used up by a tx that rolls back, and a
+// tx may commit after another that took a higher ID. So rather than wait on the
+// IDs it has not seen, each poll marks the newest ID then committed, and changes
+// are only delivered up to a mark once it is settle old. A tx that commits more
+// than settle after a higher ID was committed is not delivered.
+type ChangeFeed struct {
+	source    ChangeSource
+	interval  time.Duration
+	settle    time.Duration
+	retention time.Duration
+
+	mu sync.Mutex
+	// open counts the subscriptions not yet closed, the poller runs while it is not 0
+	open    int
+	polling bool
+	// delivered is the ID of the last change delivered to live subscriptions, -1
+	// until the poller's first mark has settled
+	delivered int64
+	marks     []changeMark
+	live      map[*Subscription]*liveSubscription
+	// err is the error of the poller's last read of the source, if it failed
+	err error
+	// advanced is closed and replaced when delivered moves or the poller fails
+	advanced chan struct{}
+}
+
+// changeMark is the newest change ID committed when the poller last looked
+type changeMark struct {
+	at time.Time
+	id int64
+}
+
+// liveSubscription is a subscription that has caught up with the poller, which
+// sends it the changes after after. The poller closes ch if it falls a full
+// buffer behind, and the subscription catches up from the source again, or when
+// reading the source failed with err.
+type liveSubscription struct {
+	ch    chan Change
+	after int64
+	err   error
+}
+
+// NewChangeFeed creates a feed that polls source every interval, delivers changes
+// once their ID has been committed for settle, and resumes from tokens for changes
+// made within retention
+func NewChangeFeed(source ChangeSource, interval, settle, retention time.Duration) *ChangeFeed {
+	return &ChangeFeed{
+		source:    source,
+		interval:  interval,
+		settle:    settle,
+		retention: retention,
+		delivered: -1,
+		live:      map[*Subscription]*liveSubscription{},
+		advanced:  make(chan struct{}),
+	}
+}
+
+// Subscribe returns a subscription to the changes after the change that issued
+// token. An empty token subscribes to new changes only. The subscription ends
+// when ctx is done or it is closed.
+func (f *ChangeFeed) Subscribe(ctx context.Context, token string) (*Subscription, error) {
+	var after int64
+	if token != "" {
+		id, at, err := parseChangeToken(token)
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(at) >= f.retention {
+			return nil, errors.WithStack(ErrChangesExpired)
+		}
+		after = id
+	} else {
+		id, err := f.source.LastChangeID(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error subscribing to changes")
+		}
+		after = id
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscription{
+		ch:     make(chan Change),
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+
+	f.mu.Lock()
+	f.open++
+	if !f.polling {
+		f.polling = true
+		go f.poll()
+	}
+	f.mu.Unlock()
+
+	go s.run(ctx, f, after)
+
+	return s, nil
+}
+
+// poll marks the newest committed change every interval and delivers the changes
+// up to the newest settled mark to live subscriptions, until no subscription is
+// open. The next subscription starts it again from a fresh mark.
+func (f *ChangeFeed) poll() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	for {
+		if err := f.advance(ctx); err != nil {
+			f.fail(err)
+		}
+
+		<-ticker.C
+
+		f.mu.Lock()
+		if f.open == 0 {
+			f.polling, f.delivered, f.marks, f.err = false, -1, nil, nil
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+	}
+}
+
+// advance marks the newest committed change and delivers the changes up to the
+// newest settled mark
+func (f *ChangeFeed) advance(ctx context.Context) error {
+	id, err := f.source.LastChangeID(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.err = nil
+	now := time.Now()
+	f.marks = append(f.marks, changeMark{at: now, id: id})
+	settled := int64(-1)
+	for len(f.marks) > 0 && now.Sub(f.marks[0].at) >= f.settle {
+		if f.marks[0].id > settled {
+			settled = f.marks[0].id
+		}
+		f.marks = f.marks[1:]
+	}
+	delivered := f.delivered
+	if delivered < 0 && settled >= 0 {
+		// nothing is live before the first mark settles, so there is nothing to deliver
+		f.setDelivered(settled)
+	}
+	f.mu.Unlock()
+
+	if delivered < 0 || settled <= delivered {
+		return nil
+	}
+
+	for delivered < settled {
+		changes, err := f.source.ChangesAfter(ctx, delivered, changeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		for _, c := range changes {
+			if c.id > settled {
+				break
+			}
+			c.Token = changeToken(c.id, c.At)
+			for s, l := range f.live {
+				if c.id <= l.after {
+					continue
+				}
+				select {
+				case l.ch <- c:
+				default:
+					delete(f.live, s)
+					close(l.ch)
+				}
+			}
+			delivered = c.id
+		}
+		if len(changes) < changeBatchSize || delivered >= settled {
+			// no change up to settled is left to read
+			delivered = settled
+		}
+		f.setDelivered(delivered)
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+// setDelivered moves delivered and wakes the subscriptions waiting on it, the
+// caller must hold f.mu
+func (f *ChangeFeed) setDelivered(id int64) {
+	f.delivered = id
+	f.wake()
+}
+
+// wake wakes the subscriptions waiting on the poller, the caller must hold f.mu
+func (f *ChangeFeed) wake() {
+	close(f.advanced)
+	f.advanced = make(chan struct{})
+}
+
+// fail ends every live subscription, and every one waiting for the poller's first
+// mark to settle, with err
+func (f *ChangeFeed) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.err = err
+	f.wake()
+	for s, l := range f.live {
+		l.err = err
+		delete(f.live, s)
+		close(l.ch)
+	}
+}
+
+// changeToken encodes the ID of a change and the time it was made
+func changeToken(id int64, at time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d/%d", id, at.Unix())))
+}
+
+// parseChangeToken decodes a token issued by changeToken
+func parseChangeToken(token string) (int64, time.Time, error) {
+	malformed := func() error { return errors.Wrap(ErrChangesExpired, "malformed resume token") }
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, time.Time{}, malformed()
+	}
+
+	parts := strings.SplitN(string(b), "/", 2)
+	if len(parts) != 2 {
+		return 0, time.Time{}, malformed()
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || id < 0 {
+		return 0, time.Time{}, malformed()
+	}
+	at, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, malformed()
+	}
+
+	return id, time.Unix(at, 0), nil
+}
+
+// Subscription receives changes from a ChangeFeed
+type Subscription struct {
+	ch     chan Change
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+// run delivers the changes after the change with ID after until ctx is done or
+// reading the source fails. While it is behind the feed's poller it reads the
+// source itself, up to the last change the poller delivered, then it joins the
+// poller's live subscriptions.
+func (s *Subscription) run(ctx context.Context, f *ChangeFeed, after int64) {
+	defer close(s.done)
+	defer close(s.ch)
+	defer func() {
+		f.mu.Lock()
+		delete(f.live, s)
+		f.open--
+		f.mu.Unlock()
+	}()
+
+	send := func(c Change) bool {
+		select {
+		case s.ch <- c:
+			after = c.id
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	end := func(err error) {
+		if ctx.Err() == nil {
+			s.mu.Lock()
+			s.err = errors.Wrap(err, "Error reading changes")
+			s.mu.Unlock()
+		}
+	}
+
+	for {
+		f.mu.Lock()
+		delivered, advanced, err := f.delivered, f.advanced, f.err
+		if delivered >= 0 && after >= delivered {
+			l := &liveSubscription{ch: make(chan Change, changeBatchSize), after: after}
+			f.live[s] = l
+			f.mu.Unlock()
+
+		live:
+			for {
+				select {
+				case c, ok := <-l.ch:
+					if !ok {
+						break live
+					}
+					if !send(c) {
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+			if l.err != nil {
+				end(l.err)
+				return
+			}
+			// dropped for falling behind, catch up from the source
+			continue
+		}
+		f.mu.Unlock()
+
+		if delivered < 0 {
+			if err != nil {
+				end(err)
+				return
+			}
+			select {
+			case <-advanced:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		changes, err := f.source.ChangesAfter(ctx, after, changeBatchSize)
+		if err != nil {
+			end(err)
+			return
+		}
+		for _, c := range changes {
+			if c.id > delivered {
+				break
+			}
+			c.Token = changeToken(c.id, c.At)
+			if !send(c) {
+				return
+			}
+		}
+		if len(changes) < changeBatchSize || changes[len(changes)-1].id >= delivered {
+			// no change up to delivered is left to read
+			after = delivered
+		}
+	}
+}
+
+// Changes returns the channel changes are delivered on. It is closed when the
+// subscription ends, after which Err reports why.
+func (s *Subscription) Changes() <-chan Change {
+	return s.ch
+}
+
+// Err returns the error reading the source that ended the subscription, if it did
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription and waits for it to stop reading the source
+func (s *Subscription) Close() {
+	s.cancel()
+	<-s.done
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeChangeSource is a slice backed ChangeSource
+type fakeChangeSource struct {
+	mu      sync.Mutex
+	changes []Change
+	err     error
+	// polls counts the calls to LastChangeID
+	polls int
+}
+
+// add commits a change with id to the source, keeping the changes in ID order as
+// a change may commit after one with a higher ID
+func (f *fakeChangeSource) add(id int64, name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := Change{
+		Type:        ChangeCreated,
+		Thunderbird: &Thunderbird{ID: uuid.New(), Name: name},
+		At:          time.Now(),
+		id:          id,
+	}
+	i := sort.Search(len(f.changes), func(i int) bool { return f.changes[i].id > id })
+	f.changes = append(f.changes[:i], append([]Change{c}, f.changes[i:]...)...)
+}
+
+func (f *fakeChangeSource) fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeChangeSource) LastChangeID(ctx context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.polls++
+	if len(f.changes) == 0 {
+		return 0, f.err
+	}
+	return f.changes[len(f.changes)-1].id, f.err
+}
+
+func (f *fakeChangeSource) ChangesAfter(ctx context.Context, after int64, limit int) ([]Change, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	changes := []Change{}
+	for _, c := range f.changes {
+		if c.id > after && len(changes) < limit {
+			changes = append(changes, c)
+		}
+	}
+	return changes, nil
+}
+
+// receive waits for up to n changes on a subscription, returning those that
+// arrive before the subscription ends or a short timeout
+func receive(s *Subscription, n int) []Change {
+	changes := []Change{}
+	timeout := time.After(100 * time.Millisecond)
+	for len(changes) < n {
+		select {
+		case c, ok := <-s.Changes():
+			if !ok {
+				return changes
+			}
+			changes = append(changes, c)
+		case <-timeout:
+			return changes
+		}
+	}
+	return changes
+}
+
+// names returns the names of the thunderbirds in changes
+func names(changes []Change) []string {
+	names := []string{}
+	for _, c := range changes {
+		names = append(names, c.Thunderbird.Name)
+	}
+	return names
+}
+
+func TestChangeFeed(t *testing.T) {
+	ctx := context.Background()
+
+	// ensures new subscribers only receive changes made after subscribing
+	t.Run("Without a token", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		source.add(1, "before")
+		f := NewChangeFeed(source, time.Millisecond, 0, time.Hour)
+
+		s, err := f.Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer s.Close()
+
+		source.add(2, "after")
+
+		changes := receive(s, 2)
+		if assert.Len(t, changes, 1, "Expected only the later change") {
+			assert.Equal(t, "after", changes[0].Thunderbird.Name, "Expected the later change")
+			assert.NotEmpty(t, changes[0].Token, "Expected a resume token")
+		}
+	})
+
+	// ensures a token replays every change made after it, including on another
+	// feed reading the same source
+	t.Run("Resuming from a token", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		f := NewChangeFeed(source, time.Millisecond, 0, time.Hour)
+
+		s, err := f.Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		source.add(1, "first")
+		source.add(2, "second")
+		source.add(3, "third")
+		first := receive(s, 1)
+		s.Close()
+		if !assert.Len(t, first, 1, "Expected the first change") {
+			return
+		}
+
+		resumed, err := NewChangeFeed(source, time.Millisecond, 0, time.Hour).Subscribe(ctx, first[0].Token)
+		assert.NoError(t, err, "Expected no error")
+		defer resumed.Close()
+
+		changes := receive(resumed, 3)
+		assert.Equal(t, []string{"second", "third"}, names(changes), "Expected the changes after the token in order")
+	})
+
+	// ensures a token older than the retention or malformed is rejected
+	t.Run("Expired tokens", func(t *testing.T) {
+		f := NewChangeFeed(&fakeChangeSource{}, time.Millisecond, 0, time.Hour)
+
+		_, err := f.Subscribe(ctx, changeToken(1, time.Now().Add(-2*time.Hour)))
+		assert.ErrorIs(t, err, ErrChangesExpired, "Expected a token past the retention to expire")
+
+		_, err = f.Subscribe(ctx, "garbage")
+		assert.ErrorIs(t, err, ErrChangesExpired, "Expected malformed token to expire")
+	})
+
+	// ensures an ID that is never committed, e.g. by a tx that rolled back, does not
+	// hold back the changes after it
+	t.Run("Rolled back IDs", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		source.add(1, "first")
+		f := NewChangeFeed(source, time.Millisecond, 10*time.Millisecond, time.Hour)
+
+		s, err := f.Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer s.Close()
+
+		source.add(3, "third")
+		assert.Equal(t, []string{"third"}, names(receive(s, 1)), "Expected the change after the skipped ID")
+	})
+
+	// ensures a change committed within the settle delay of a change with a higher
+	// ID is delivered, in ID order
+	t.Run("Late commits", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		f := NewChangeFeed(source, time.Millisecond, 50*time.Millisecond, time.Hour)
+
+		s, err := f.Subscribe(ctx, changeToken(0, time.Now()))
+		assert.NoError(t, err, "Expected no error")
+		defer s.Close()
+
+		source.add(2, "second")
+		time.Sleep(10 * time.Millisecond)
+		source.add(1, "first")
+
+		assert.Equal(t, []string{"first", "second"}, names(receive(s, 2)), "Expected both changes in order")
+	})
+
+	// ensures the loss window: a change committed more than the settle delay after a
+	// change with a higher ID was delivered is skipped by live subscriptions, while a
+	// subscription resuming from before it still reads it from the source
+	t.Run("Commits after the settle delay", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		source.add(1, "first")
+		f := NewChangeFeed(source, time.Millisecond, 0, time.Hour)
+
+		s, err := f.Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer s.Close()
+
+		source.add(3, "third")
+		assert.Equal(t, []string{"third"}, names(receive(s, 1)), "Expected the change after the open tx")
+
+		source.add(2, "second")
+		source.add(4, "fourth")
+		assert.Equal(t, []string{"fourth"}, names(receive(s, 2)), "Expected the late change to be skipped")
+
+		source.mu.Lock()
+		token := changeToken(1, source.changes[0].At)
+		source.mu.Unlock()
+		resumed, err := f.Subscribe(ctx, token)
+		assert.NoError(t, err, "Expected no error")
+		defer resumed.Close()
+
+		assert.Equal(t, []string{"second", "third", "fourth"}, names(receive(resumed, 3)), "Expected the late change when resuming")
+	})
+
+	// ensures subscriptions share a single poller, so polling the source does not
+	// grow with the number of subscribers
+	t.Run("Shared poller", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		source.add(1, "first")
+		f := NewChangeFeed(source, time.Hour, 0, time.Hour)
+
+		for i := 0; i < 10; i++ {
+			s, err := f.Subscribe(ctx, changeToken(0, time.Now()))
+			assert.NoError(t, err, "Expected no error")
+			defer s.Close()
+
+			assert.Equal(t, []string{"first"}, names(receive(s, 1)), "Expected every subscription to receive the change")
+		}
+
+		source.mu.Lock()
+		defer source.mu.Unlock()
+		assert.Equal(t, 1, source.polls, "Expected a single poll for every subscription")
+	})
+
+	// ensures a subscriber that falls behind the poller catches up from the source
+	// without missing or repeating changes
+	t.Run("Slow subscribers", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		f := NewChangeFeed(source, time.Millisecond, 0, time.Hour)
+
+		s, err := f.Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer s.Close()
+
+		n := 3 * changeBatchSize
+		want := []string{}
+		for i := 1; i <= n; i++ {
+			name := strconv.Itoa(i)
+			source.add(int64(i), name)
+			want = append(want, name)
+		}
+		time.Sleep(20 * time.Millisecond)
+
+		assert.Equal(t, want, names(receive(s, n)), "Expected every change once in order")
+	})
+
+	// ensures a failure reading the source ends the subscription with the error
+	t.Run("Source error", func(t *testing.T) {
+		source := &fakeChangeSource{}
+		f := NewChangeFeed(source, time.Millisecond, 0, time.Hour)
+
+		s, err := f.Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer s.Close()
+
+		source.fail(errors.New("connection lost"))
+
+		assert.Empty(t, receive(s, 1), "Expected no changes")
+		_, ok := <-s.Changes()
+		assert.False(t, ok, "Expected the subscription to end")
+		assert.Error(t, s.Err(), "Expected the source error")
+	})
+
+	// ensures closing a subscription ends it without an error
+	t.Run("Close", func(t *testing.T) {
+		s, err := NewChangeFeed(&fakeChangeSource{}, time.Millisecond, 0, time.Hour).Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+
+		s.Close()
+		_, ok := <-s.Changes()
+		assert.False(t, ok, "Expected the subscription to end")
+		assert.NoError(t, s.Err(), "Expected no error")
+	})
+}
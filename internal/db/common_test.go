@@ -1,7 +1,10 @@
 package db
 
 import (
+	"database/sql"
+
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/caring/go-packages/pkg/errors"
 )
 
 // NewTestDB creates a testable store instance with a mocked sql driver
@@ -16,19 +19,66 @@ func NewTestDB(stmts map[string]string) (*Store, sqlmock.Sqlmock, error) {
 		return nil, nil, err
 	}
 
-	for _, s := range stmts {
-		mock.ExpectPrepare(s)
+	prepared, err := prepareTestStmts(db, mock, stmts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newStore(db, prepared, nil), mock, nil
+}
+
+// NewTestDBWithReplica creates a testable store like NewTestDB, along with a mocked
+// read replica that reads outside of a tx are routed to
+func NewTestDBWithReplica(stmts map[string]string) (*Store, sqlmock.Sqlmock, sqlmock.Sqlmock, error) {
+	s, mock, err := NewTestDB(stmts)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	prepared, err := prepareStmts(db, stmts)
+	reader, readerMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
+	}
+
+	prepared, err := prepareTestStmts(reader, readerMock, stmts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	s.replica = &replica{db: reader, stmts: prepared}
+	s.Thunderbird = newThunderbirdService(s.db, s.stmts, s.hooks, s.retry, s.replica)
+
+	return s, mock, readerMock, nil
+}
+
+// lookupStmtName returns the statement whose name is text
+func lookupStmtName(text string) (stmtName, bool) {
+	for name, t := range stmtNameText {
+		if t == text {
+			return stmtName(name), true
+		}
 	}
+	return 0, false
+}
 
-	s := Store{
-		db:    db,
-		stmts: prepared,
+// prepareTestStmts prepares stmts, a subset of the statements keyed by name, on a
+// mocked db. Statements left out fail with ErrUnknownStatement when they are used.
+func prepareTestStmts(db *sql.DB, mock sqlmock.Sqlmock, stmts map[string]string) (*stmtRegistry, error) {
+	queries := map[stmtName]string{}
+	for k, v := range stmts {
+		name, ok := lookupStmtName(k)
+		if !ok {
+			return nil, errors.New("unknown statement " + k)
+		}
+		queries[name] = v
 	}
 
-	return &s, mock, nil
+	registry := newStmtRegistry(db, queries)
+	for _, k := range registry.names() {
+		mock.ExpectPrepare(queries[k])
+	}
+	if failures := registry.prepare(); len(failures) > 0 {
+		return nil, &PrepareError{Failures: failures}
+	}
+	return registry, nil
 }
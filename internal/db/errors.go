@@ -7,6 +7,15 @@ var (
 	ErrNoRows = errors.New("no rows")
 	// ErrNoRowsAffected occurs when no rows were updated
 	ErrNoRowsAffected = errors.New("no rows affected")
-	// ErrNotFound when a specific reqcord was not found
-	ErrNotFound = errors.New("the record you are attempting to update is not found")
+	// ErrNotFound when a specific record was not found
+	ErrNotFound = errors.New("the record you are attempting to find or update is not found")
+	// ErrNotCreated occurs when an insert did not create any rows
+	ErrNotCreated = errors.New("no new rows were created")
+	// ErrConflict occurs when a record was modified since the version the caller read
+	ErrConflict = errors.New("the record has been modified since it was read")
+	// ErrUnknownField occurs when an update names a field that cannot be written
+	ErrUnknownField = errors.New("unknown or read only field")
+	// ErrIdempotencyKeyReused occurs when an idempotency key is replayed with a
+	// request that differs from the one it was first used with
+	ErrIdempotencyKeyReused = errors.New("the idempotency key was used by a different request")
 )
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Query is a statement reported to a QueryHook, with the statement's name from
+// statements, or the name of the dynamic query it runs, and the args it runs with
+type Query struct {
+	Name string
+	Args []interface{}
+
+	stmt stmtName
+}
+
+// QueryResult is the outcome of a statement reported to a QueryHook.
+// RowsAffected is -1 for statements that return rows, and for execs that failed.
+type QueryResult struct {
+	Err          error
+	RowsAffected int64
+}
+
+// QueryHook is called as a statement starts. The statement runs with the ctx the
+// hook returns, and the func it returns is called with the statement's result
+// once the statement has run.
+type QueryHook func(ctx context.Context, q Query) (context.Context, func(r QueryResult))
+
+// queryHooks holds the hooks added to a Store, shared with each of its services
+type queryHooks struct {
+	hooks []QueryHook
+}
+
+// AddQueryHook registers h to be called around every statement the store runs.
+// Hooks must be added before the store is used.
+func (s *Store) AddQueryHook(h QueryHook) {
+	s.hooks.hooks = append(s.hooks.hooks, h)
+}
+
+// start calls every hook for the named statement run with args, and returns the
+// ctx the statement runs with and a func to call once it has run, with the
+// sql.Result of an exec or nil for a query, and its error
+func (h *queryHooks) start(ctx context.Context, name stmtName, args ...interface{}) (context.Context, func(result sql.Result, err error)) {
+	if h == nil || len(h.hooks) == 0 {
+		return ctx, func(sql.Result, error) {}
+	}
+
+	q := Query{Name: name.String(), Args: args, stmt: name}
+	dones := make([]func(QueryResult), len(h.hooks))
+	for i, hook := range h.hooks {
+		ctx, dones[i] = hook(ctx, q)
+	}
+	return ctx, func(result sql.Result, err error) {
+		r := QueryResult{Err: err, RowsAffected: -1}
+		if result != nil && err == nil {
+			if n, err := result.RowsAffected(); err == nil {
+				r.RowsAffected = n
+			}
+		}
+		for i := len(dones) - 1; i >= 0; i-- {
+			dones[i](r)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// hookCall is a statement reported to a QueryHook
+type hookCall struct {
+	name         string
+	args         int
+	rowsAffected int64
+	err          error
+}
+
+func TestStore_AddQueryHook(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"delete-thunderbird":  "UPDATE thunderbirds",
+		"create-outbox-event": "INSERT outbox",
+	}
+
+	// ensures every statement of a mutation is reported by name with its args,
+	// rows affected and error
+	t.Run("Reports each statement", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		calls := []hookCall{}
+		order := []string{}
+		for _, label := range []string{"first", "second"} {
+			label := label
+			store.AddQueryHook(func(ctx context.Context, q Query) (context.Context, func(QueryResult)) {
+				order = append(order, "start "+label)
+				return ctx, func(r QueryResult) {
+					order = append(order, "done "+label)
+					if label == "first" {
+						calls = append(calls, hookCall{name: q.Name, args: len(q.Args), rowsAffected: r.RowsAffected, err: r.Err})
+					}
+				}
+			})
+		}
+
+		failed := errors.New("outbox unavailable")
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WillReturnError(failed)
+		mock.ExpectRollback()
+
+		err = store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.Error(t, err, "Expected the outbox error")
+
+		assert.Equal(t, []hookCall{
+			{name: "delete-thunderbird", args: 1, rowsAffected: 1},
+			{name: "create-outbox-event", args: 4, rowsAffected: -1, err: failed},
+		}, calls, "Expected each statement to be reported")
+		assert.Equal(t, []string{"start first", "start second", "done second", "done first"}, order[:4], "Expected hooks to unwind in reverse")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expected all expectations to be met")
+	})
+}
@@ -0,0 +1,376 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// newIntegrationStore starts an in process MySQL compatible server, creates and
+// migrates a schema on it the way the migrate subcommand does, then connects a
+// Store with every statement prepared against the real schema. The server and
+// Store are closed when the test ends.
+func newIntegrationStore(t *testing.T) *Store {
+	t.Helper()
+
+	pro := memory.NewDBProvider()
+	engine := sqle.NewDefault(pro)
+	srv, err := server.NewServer(server.Config{Protocol: "tcp", Address: "127.0.0.1:0"}, engine, memory.NewSessionBuilder(pro), nil)
+	if err != nil {
+		t.Fatalf("starting server: %v", err)
+	}
+	go srv.Start()
+	t.Cleanup(func() { srv.Close() })
+
+	dsn := "root@tcp(" + srv.Listener.Addr().String() + ")/thunderbird?parseTime=true"
+
+	if err = EnsureSchema(dsn); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+
+	src, err := iofs.New(Migrations, "migrations")
+	if err != nil {
+		t.Fatalf("reading migrations: %v", err)
+	}
+	m, err := migrate.NewWithSourceInstance("iofs", src, "mysql://"+dsn)
+	if err != nil {
+		t.Fatalf("connecting migrations: %v", err)
+	}
+	defer m.Close()
+	if err = m.Up(); err != nil {
+		t.Fatalf("running migrations: %v", err)
+	}
+
+	s, err := NewStore(dsn, "")
+	if err != nil {
+		t.Fatalf("connecting store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestIntegration_migrations(t *testing.T) {
+	s := newIntegrationStore(t)
+
+	// ensures every migration applied cleanly and a second run is a no-op
+	t.Run("Up to date", func(t *testing.T) {
+		var (
+			version int
+			dirty   bool
+		)
+		err := s.db.QueryRow("SELECT version, dirty FROM schema_migrations").Scan(&version, &dirty)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 10009, version, "Expected the latest migration to be applied")
+		assert.False(t, dirty, "Expected a clean migration")
+	})
+
+	// ensures every statement prepared against the migrated schema
+	t.Run("Statements prepared", func(t *testing.T) {
+		for _, k := range stmtNames(statements) {
+			assert.NotNil(t, s.stmts.stmts[k], "Expected "+k.String()+" to be prepared")
+		}
+	})
+}
+
+func TestIntegration_thunderbirds(t *testing.T) {
+	ctx := context.Background()
+
+	// ensures rows round trip through UUID_TO_BIN and the generated id column
+	t.Run("Create and get", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		ID := uuid.New()
+
+		err := s.Thunderbird.Create(ctx, &Thunderbird{ID: ID, Name: "Foobar"})
+		assert.NoError(t, err, "Expected no error")
+		err = s.Thunderbird.Create(ctx, &Thunderbird{ID: ID, Name: "Foobar"})
+		mysqlErr := &mysql.MySQLError{}
+		if assert.True(t, errors.As(err, &mysqlErr), "Expected a MySQL error for a duplicate ID") {
+			assert.Equal(t, uint16(1062), mysqlErr.Number, "Expected a duplicate entry error")
+		}
+
+		r, err := s.Thunderbird.Get(ctx, ID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, ID, r.ID, "Expected the stored ID")
+		assert.Equal(t, "Foobar", r.Name, "Expected the stored name")
+		assert.Equal(t, int64(1), r.Version, "Expected a new record at version 1")
+		assert.False(t, r.CreatedAt.IsZero(), "Expected created at to be set")
+		assert.Nil(t, r.DeletedAt, "Expected deleted at to be unset")
+
+		var text string
+		err = s.db.QueryRow("SELECT thunderbird_id_text FROM thunderbirds WHERE thunderbird_id = UUID_TO_BIN(?)", ID).Scan(&text)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, ID.String(), text, "Expected the generated column to match the ID")
+
+		_, err = s.Thunderbird.Get(ctx, uuid.New())
+		assert.ErrorIs(t, err, ErrNotFound, "Expected a missing record to be not found")
+	})
+
+	// ensures versioned and field masked updates are applied and conflicts detected
+	t.Run("Update", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		ID := uuid.New()
+		assert.NoError(t, s.Thunderbird.Create(ctx, &Thunderbird{ID: ID, Name: "Foobar"}), "Expected no error")
+
+		in := &Thunderbird{ID: ID, Name: "Bazqux", Version: 1}
+		assert.NoError(t, s.Thunderbird.Update(ctx, in), "Expected no error")
+		assert.Equal(t, int64(2), in.Version, "Expected the version to be bumped")
+
+		err := s.Thunderbird.Update(ctx, &Thunderbird{ID: ID, Name: "Stale", Version: 1})
+		assert.ErrorIs(t, err, ErrConflict, "Expected a stale version to conflict")
+
+		err = s.Thunderbird.UpdateFields(ctx, &Thunderbird{ID: ID, Name: "Quux"}, []string{"name"})
+		assert.NoError(t, err, "Expected no error")
+
+		r, err := s.Thunderbird.Get(ctx, ID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Quux", r.Name, "Expected the latest name")
+		assert.Equal(t, int64(3), r.Version, "Expected one bump per update")
+
+		err = s.Thunderbird.Update(ctx, &Thunderbird{ID: uuid.New(), Name: "Missing"})
+		assert.ErrorIs(t, err, ErrNoRowsAffected, "Expected a missing record to affect no rows")
+	})
+
+	// ensures soft deleted rows are hidden from reads and writes until restored
+	t.Run("Soft delete", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		deleted, kept := uuid.New(), uuid.New()
+		assert.NoError(t, s.Thunderbird.Create(ctx, &Thunderbird{ID: deleted, Name: "alpha"}), "Expected no error")
+		assert.NoError(t, s.Thunderbird.Create(ctx, &Thunderbird{ID: kept, Name: "bravo"}), "Expected no error")
+
+		assert.NoError(t, s.Thunderbird.Delete(ctx, deleted), "Expected no error")
+		assert.ErrorIs(t, s.Thunderbird.Delete(ctx, deleted), ErrNotFound, "Expected a second delete to be not found")
+
+		_, err := s.Thunderbird.Get(ctx, deleted)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected get to skip deleted records")
+
+		err = s.Thunderbird.Update(ctx, &Thunderbird{ID: deleted, Name: "alpha"})
+		assert.ErrorIs(t, err, ErrNoRowsAffected, "Expected update to skip deleted records")
+
+		r, err := s.Thunderbird.Load(ctx, []uuid.UUID{deleted, kept})
+		assert.NoError(t, err, "Expected no error")
+		if assert.Len(t, r, 1, "Expected load to skip deleted records") {
+			assert.Equal(t, kept, r[0].ID, "Expected the live record")
+		}
+
+		r, err = s.Thunderbird.List(ctx, &ListThunderbirdsParams{Limit: 10})
+		assert.NoError(t, err, "Expected no error")
+		assert.Len(t, r, 1, "Expected list to skip deleted records")
+
+		r, err = s.Thunderbird.List(ctx, &ListThunderbirdsParams{Limit: 10, IncludeDeleted: true})
+		assert.NoError(t, err, "Expected no error")
+		if assert.Len(t, r, 2, "Expected list to include deleted records on request") {
+			assert.NotNil(t, r[0].DeletedAt, "Expected deleted at to be set")
+		}
+
+		assert.NoError(t, s.Thunderbird.Restore(ctx, deleted), "Expected no error")
+		assert.ErrorIs(t, s.Thunderbird.Restore(ctx, deleted), ErrNotFound, "Expected a second restore to be not found")
+		_, err = s.Thunderbird.Get(ctx, deleted)
+		assert.NoError(t, err, "Expected the restored record")
+	})
+
+	// ensures pages follow on from each other through the keyset cursor
+	t.Run("List pages", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		for _, name := range []string{"alpha", "bravo", "charlie"} {
+			assert.NoError(t, s.Thunderbird.Create(ctx, &Thunderbird{ID: uuid.New(), Name: name}), "Expected no error")
+		}
+
+		first, err := s.Thunderbird.List(ctx, &ListThunderbirdsParams{Limit: 2})
+		assert.NoError(t, err, "Expected no error")
+		if assert.Len(t, first, 2, "Expected a full first page") {
+			assert.Equal(t, "alpha", first[0].Name, "Expected name order")
+			assert.Equal(t, "bravo", first[1].Name, "Expected name order")
+		}
+
+		rest, err := s.Thunderbird.List(ctx, &ListThunderbirdsParams{Limit: 2, After: first[1].Cursor()})
+		assert.NoError(t, err, "Expected no error")
+		if assert.Len(t, rest, 1, "Expected the remaining record") {
+			assert.Equal(t, "charlie", rest[0].Name, "Expected the page after the cursor")
+		}
+
+		r, err := s.Thunderbird.List(ctx, &ListThunderbirdsParams{Limit: 10, NamePrefix: "br"})
+		assert.NoError(t, err, "Expected no error")
+		assert.Len(t, r, 1, "Expected the name prefix to filter")
+	})
+
+	// ensures a replayed idempotency key resolves to the first create
+	t.Run("Idempotent create", func(t *testing.T) {
+		s := newIntegrationStore(t)
+
+		first := &Thunderbird{ID: uuid.New(), Name: "Foobar"}
+		created, err := s.Thunderbird.CreateIdempotent(ctx, first, "req-1", []byte("Foobar"), time.Hour)
+		assert.NoError(t, err, "Expected no error")
+		assert.True(t, created, "Expected the first create to insert")
+
+		replay := &Thunderbird{ID: uuid.New(), Name: "Foobar"}
+		created, err = s.Thunderbird.CreateIdempotent(ctx, replay, "req-1", []byte("Foobar"), time.Hour)
+		assert.NoError(t, err, "Expected no error")
+		assert.False(t, created, "Expected the replay not to insert")
+		assert.Equal(t, first.ID, replay.ID, "Expected the original ID")
+		assert.Equal(t, int64(1), replay.Version, "Expected the original thunderbird to be read")
+
+		_, err = s.Thunderbird.CreateIdempotent(ctx, &Thunderbird{ID: uuid.New(), Name: "Bazqux"}, "req-1", []byte("Bazqux"), time.Hour)
+		assert.ErrorIs(t, err, ErrIdempotencyKeyReused, "Expected a different request to be rejected")
+
+		n, err := s.Thunderbird.PurgeIdempotencyKeys(ctx, 10)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 0, n, "Expected live keys to be kept")
+	})
+
+	// ensures old soft deleted records are hard deleted
+	t.Run("Purge", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		ID := uuid.New()
+		assert.NoError(t, s.Thunderbird.Create(ctx, &Thunderbird{ID: ID, Name: "Foobar"}), "Expected no error")
+		assert.NoError(t, s.Thunderbird.Delete(ctx, ID), "Expected no error")
+
+		n, err := s.Thunderbird.Purge(ctx, time.Now().Add(time.Hour), 10)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 1, n, "Expected the deleted record to be purged")
+
+		r, err := s.Thunderbird.List(ctx, &ListThunderbirdsParams{Limit: 10, IncludeDeleted: true})
+		assert.NoError(t, err, "Expected no error")
+		assert.Empty(t, r, "Expected no records to remain")
+	})
+
+	// ensures every mutation records an outbox event that the relay drains
+	t.Run("Outbox", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		ID := uuid.New()
+		assert.NoError(t, s.Thunderbird.Create(ctx, &Thunderbird{ID: ID, Name: "Foobar"}), "Expected no error")
+		assert.NoError(t, s.Thunderbird.Delete(ctx, ID), "Expected no error")
+
+		var events []*OutboxEvent
+		n, err := s.Outbox.Relay(ctx, 10, func(_ context.Context, e []*OutboxEvent) error {
+			events = append(events, e...)
+			return nil
+		})
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 2, n, "Expected one event per mutation")
+		if assert.Len(t, events, 2, "Expected the events to be published") {
+			assert.Equal(t, ID, events[0].AggregateID, "Expected the mutated record")
+		}
+
+		n, err = s.Outbox.Relay(ctx, 10, func(context.Context, []*OutboxEvent) error { return nil })
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 0, n, "Expected published events to be marked")
+
+		n, err = s.Outbox.Purge(ctx, 10)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 0, n, "Expected published events to be retained for the change feed")
+	})
+
+	// ensures the change feed reads every committed mutation from the outbox,
+	// including a tx committed directly, and a token resumes on another feed
+	t.Run("Changes", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		ID := uuid.New()
+		sub, err := newIntegrationFeed(s).Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer sub.Close()
+
+		tx, err := s.GetTx()
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, s.Thunderbird.CreateTx(ToCtx(ctx, tx), &Thunderbird{ID: ID, Name: "Foobar"}), "Expected no error")
+		assert.NoError(t, tx.Commit(), "Expected no error")
+
+		created := receive(sub, 1)
+		if !assert.Len(t, created, 1, "Expected the change committed on the *sql.Tx") {
+			return
+		}
+		assert.Equal(t, ChangeCreated, created[0].Type, "Expected a create change")
+		assert.Equal(t, "Foobar", created[0].Thunderbird.Name, "Expected the created record")
+
+		assert.NoError(t, s.Thunderbird.Delete(ctx, ID), "Expected no error")
+
+		resumed, err := newIntegrationFeed(s).Subscribe(ctx, created[0].Token)
+		assert.NoError(t, err, "Expected no error")
+		defer resumed.Close()
+
+		deleted := receive(resumed, 1)
+		if assert.Len(t, deleted, 1, "Expected the change after the token") {
+			assert.Equal(t, ChangeDeleted, deleted[0].Type, "Expected a delete change")
+			assert.Equal(t, ID, deleted[0].Thunderbird.ID, "Expected the deleted ID")
+		}
+	})
+}
+
+// newIntegrationFeed returns a feed on the outbox of s, polled quickly enough for
+// receive to see its changes
+func newIntegrationFeed(s *Store) *ChangeFeed {
+	return NewChangeFeed(s.Outbox.(*outboxService), time.Millisecond, 0, changeRetention)
+}
+
+func TestIntegration_transactions(t *testing.T) {
+	ctx := context.Background()
+
+	// ensures committed writes are visible and published
+	t.Run("Commit", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		ID := uuid.New()
+		sub, err := newIntegrationFeed(s).Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer sub.Close()
+
+		txCtx, err := s.BeginTx(ctx)
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, s.Thunderbird.CreateTx(txCtx, &Thunderbird{ID: ID, Name: "Foobar"}), "Expected no error")
+
+		_, err = s.Thunderbird.GetTx(txCtx, ID)
+		assert.NoError(t, err, "Expected the write to be visible inside the tx")
+		assert.Empty(t, receive(sub, 1), "Expected no change before commit")
+
+		assert.NoError(t, s.CommitTx(txCtx), "Expected no error")
+		_, err = s.Thunderbird.Get(ctx, ID)
+		assert.NoError(t, err, "Expected the write to be visible after commit")
+		assert.Len(t, receive(sub, 1), 1, "Expected the change to be published on commit")
+	})
+
+	// ensures rolled back writes, and their outbox events, are discarded
+	t.Run("Rollback", func(t *testing.T) {
+		s := newIntegrationStore(t)
+		kept, discarded := uuid.New(), uuid.New()
+		assert.NoError(t, s.Thunderbird.Create(ctx, &Thunderbird{ID: kept, Name: "Foobar"}), "Expected no error")
+
+		txCtx, err := s.BeginTx(ctx)
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, s.Thunderbird.CreateTx(txCtx, &Thunderbird{ID: discarded, Name: "Bazqux"}), "Expected no error")
+		assert.NoError(t, s.Thunderbird.DeleteTx(txCtx, kept), "Expected no error")
+		assert.NoError(t, s.RollbackTx(txCtx), "Expected no error")
+
+		_, err = s.Thunderbird.Get(ctx, discarded)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected the create to be discarded")
+		_, err = s.Thunderbird.Get(ctx, kept)
+		assert.NoError(t, err, "Expected the delete to be discarded")
+
+		var count int
+		err = s.db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&count)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 1, count, "Expected only the committed create's event")
+	})
+
+	// ensures a failed mutation inside a tx leaves it usable for rollback
+	t.Run("Failed mutation", func(t *testing.T) {
+		s := newIntegrationStore(t)
+
+		txCtx, err := s.BeginTx(ctx)
+		assert.NoError(t, err, "Expected no error")
+		err = s.Thunderbird.DeleteTx(txCtx, uuid.New())
+		assert.ErrorIs(t, err, ErrNotFound, "Expected a missing record to be not found")
+		assert.NoError(t, s.RollbackTx(txCtx), "Expected no error")
+	})
+}
@@ -0,0 +1,683 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+)
+
+// memoryChangePollInterval is how often a subscription to the MemoryStore's feed
+// polls its change log
+const memoryChangePollInterval = 10 * time.Millisecond
+
+type memoryCtxKey struct{}
+
+var memoryTxCtxKey = memoryCtxKey{}
+
+// MemoryStore is an in process Storage for tests and local development. It
+// follows the not found, soft delete, versioning and idempotency semantics of
+// Store, with two differences from MySQL: names compare byte wise rather than
+// by collation, and concurrent transactions are not isolated from conflicting
+// writes, the last to commit wins.
+type MemoryStore struct {
+	mu      sync.Mutex
+	rows    map[uuid.UUID]*Thunderbird
+	keys    map[string]memoryKey
+	log     *memoryChangeLog
+	changes *ChangeFeed
+	now     func() time.Time
+
+	thunderbird *memoryThunderbirdService
+}
+
+// memoryKey is an idempotency key, the thunderbird its create made and the hash
+// of that create's request
+type memoryKey struct {
+	ID          uuid.UUID
+	RequestHash []byte
+	ExpiresAt   time.Time
+}
+
+// memoryTx stages the writes made inside of a MemoryStore transaction. A nil
+// row marks a purged thunderbird. done is read without the store's mu, so it is
+// atomic.
+type memoryTx struct {
+	rows    map[uuid.UUID]*Thunderbird
+	keys    map[string]memoryKey
+	changes []Change
+	done    atomic.Bool
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		rows: map[uuid.UUID]*Thunderbird{},
+		keys: map[string]memoryKey{},
+		now:  time.Now,
+	}
+	s.log = &memoryChangeLog{now: func() time.Time { return s.now() }}
+	// the log assigns IDs as changes commit, so there are none to wait on
+	s.changes = NewChangeFeed(s.log, memoryChangePollInterval, 0, changeRetention)
+	s.thunderbird = &memoryThunderbirdService{store: s}
+	return s
+}
+
+// memoryChangeLog is the ChangeSource of a MemoryStore, holding the changes
+// committed within changeRetention in the order they were committed
+type memoryChangeLog struct {
+	mu      sync.Mutex
+	lastID  int64
+	changes []Change
+	now     func() time.Time
+}
+
+var _ ChangeSource = &memoryChangeLog{}
+
+// append assigns IDs to committed changes and adds them to the log, dropping the
+// changes that can no longer be resumed from
+func (l *memoryChangeLog) append(changes ...Change) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, c := range changes {
+		l.lastID++
+		c.id = l.lastID
+		c.At = now
+		l.changes = append(l.changes, c)
+	}
+
+	i := 0
+	for i < len(l.changes) && now.Sub(l.changes[i].At) >= changeRetention {
+		i++
+	}
+	l.changes = l.changes[i:]
+}
+
+// LastChangeID returns the ID of the newest change
+func (l *memoryChangeLog) LastChangeID(ctx context.Context) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastID, nil
+}
+
+// ChangesAfter returns up to limit of the changes with an ID greater than after
+func (l *memoryChangeLog) ChangesAfter(ctx context.Context, after int64, limit int) ([]Change, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	i := sort.Search(len(l.changes), func(i int) bool { return l.changes[i].id > after })
+	changes := l.changes[i:]
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return append([]Change(nil), changes...), nil
+}
+
+// Seed stores rows as given, including their timestamps, version and deletion,
+// without recording changes. Rows with no version are stored at version 1.
+func (s *MemoryStore) Seed(rows ...*Thunderbird) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range rows {
+		c := copyThunderbird(r)
+		if c.Version == 0 {
+			c.Version = 1
+		}
+		s.rows[c.ID] = c
+	}
+}
+
+// Thunderbirds returns the in memory thunderbird service
+func (s *MemoryStore) Thunderbirds() ThunderbirdStore {
+	return s.thunderbird
+}
+
+// Subscribe returns a subscription to the store's committed mutations after token
+func (s *MemoryStore) Subscribe(ctx context.Context, token string) (*Subscription, error) {
+	return s.changes.Subscribe(ctx, token)
+}
+
+// BeginTx starts a transaction and returns a ctx carrying it
+func (s *MemoryStore) BeginTx(ctx context.Context) (context.Context, error) {
+	return context.WithValue(ctx, memoryTxCtxKey, &memoryTx{
+		rows: map[uuid.UUID]*Thunderbird{},
+		keys: map[string]memoryKey{},
+	}), nil
+}
+
+// CommitTx applies the writes staged in the tx from ctx and logs its changes
+func (s *MemoryStore) CommitTx(ctx context.Context) error {
+	tx, err := memoryTxFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if !tx.done.CompareAndSwap(false, true) {
+		s.mu.Unlock()
+		return errTxDone
+	}
+	for ID, r := range tx.rows {
+		if r == nil {
+			delete(s.rows, ID)
+			continue
+		}
+		s.rows[ID] = r
+	}
+	for k, v := range tx.keys {
+		s.keys[k] = v
+	}
+	// logged under mu so changes are in the order their writes were applied
+	s.log.append(tx.changes...)
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RollbackTx discards the tx from ctx
+func (s *MemoryStore) RollbackTx(ctx context.Context) error {
+	tx, err := memoryTxFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !tx.done.CompareAndSwap(false, true) {
+		return errTxDone
+	}
+	return nil
+}
+
+// Ping always succeeds
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// errTxDone is returned for a memory tx that has already been committed or rolled back
+var errTxDone = errors.New("memory tx has already been committed or rolled back")
+
+// memoryTxFromCtx extracts the memoryTx stored by MemoryStore.BeginTx, returns a
+// error if no tx is present or it has already been committed or rolled back
+func memoryTxFromCtx(ctx context.Context) (*memoryTx, error) {
+	val := ctx.Value(memoryTxCtxKey)
+	if val == nil {
+		return nil, errors.New("No memory tx present in context")
+	}
+	tx := val.(*memoryTx)
+	if tx.done.Load() {
+		return nil, errTxDone
+	}
+	return tx, nil
+}
+
+// copyThunderbird returns a deep copy of m so callers cannot alias stored rows
+func copyThunderbird(m *Thunderbird) *Thunderbird {
+	c := *m
+	if m.DeletedAt != nil {
+		d := *m.DeletedAt
+		c.DeletedAt = &d
+	}
+	return &c
+}
+
+// memoryView reads through the writes staged in a tx to the committed rows, and
+// writes to the tx when there is one or straight to the committed rows otherwise.
+// The caller must hold the store's mu.
+type memoryView struct {
+	store *MemoryStore
+	tx    *memoryTx
+}
+
+func (v *memoryView) get(ID uuid.UUID) *Thunderbird {
+	if v.tx != nil {
+		if r, ok := v.tx.rows[ID]; ok {
+			return r
+		}
+	}
+	return v.store.rows[ID]
+}
+
+func (v *memoryView) put(r *Thunderbird) {
+	if v.tx != nil {
+		v.tx.rows[r.ID] = r
+		return
+	}
+	v.store.rows[r.ID] = r
+}
+
+func (v *memoryView) purge(ID uuid.UUID) {
+	if v.tx != nil {
+		v.tx.rows[ID] = nil
+		return
+	}
+	delete(v.store.rows, ID)
+}
+
+func (v *memoryView) all() []*Thunderbird {
+	results := []*Thunderbird{}
+	for ID, r := range v.store.rows {
+		if v.tx != nil {
+			if _, ok := v.tx.rows[ID]; ok {
+				continue
+			}
+		}
+		results = append(results, r)
+	}
+	if v.tx != nil {
+		for _, r := range v.tx.rows {
+			if r != nil {
+				results = append(results, r)
+			}
+		}
+	}
+	return results
+}
+
+func (v *memoryView) key(k string) (memoryKey, bool) {
+	if v.tx != nil {
+		if mk, ok := v.tx.keys[k]; ok {
+			return mk, true
+		}
+	}
+	mk, ok := v.store.keys[k]
+	return mk, ok
+}
+
+func (v *memoryView) putKey(k string, mk memoryKey) {
+	if v.tx != nil {
+		v.tx.keys[k] = mk
+		return
+	}
+	v.store.keys[k] = mk
+}
+
+// record logs a change, or holds it until the tx is committed
+func (v *memoryView) record(c Change) {
+	if v.tx != nil {
+		v.tx.changes = append(v.tx.changes, c)
+		return
+	}
+	v.store.log.append(c)
+}
+
+// memoryThunderbirdService is the MemoryStore implementation of ThunderbirdStore
+type memoryThunderbirdService struct {
+	store *MemoryStore
+}
+
+var _ ThunderbirdStore = &memoryThunderbirdService{}
+
+// do runs fn against a view of the store while holding its lock. if useTx = true
+// the view is of the tx from ctx.
+func (svc *memoryThunderbirdService) do(ctx context.Context, useTx bool, fn func(v *memoryView) error) error {
+	v := &memoryView{store: svc.store}
+	if useTx {
+		tx, err := memoryTxFromCtx(ctx)
+		if err != nil {
+			return err
+		}
+		v.tx = tx
+	}
+
+	svc.store.mu.Lock()
+	defer svc.store.mu.Unlock()
+	return fn(v)
+}
+
+// now returns the store's clock at the precision of a DATETIME column
+func (svc *memoryThunderbirdService) now() time.Time {
+	return svc.store.now().UTC().Truncate(time.Second)
+}
+
+// Get fetches a single thunderbird
+func (svc *memoryThunderbirdService) Get(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
+	return svc.get(ctx, false, false, ID)
+}
+
+// GetTx fetches a single thunderbird inside of a tx from ctx
+func (svc *memoryThunderbirdService) GetTx(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
+	return svc.get(ctx, true, false, ID)
+}
+
+// GetDeleted fetches a single soft deleted thunderbird
+func (svc *memoryThunderbirdService) GetDeleted(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
+	return svc.get(ctx, false, true, ID)
+}
+
+// GetDeletedTx fetches a single soft deleted thunderbird inside of a tx from ctx
+func (svc *memoryThunderbirdService) GetDeletedTx(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
+	return svc.get(ctx, true, true, ID)
+}
+
+func (svc *memoryThunderbirdService) get(ctx context.Context, useTx, deleted bool, ID uuid.UUID) (*Thunderbird, error) {
+	var result *Thunderbird
+	err := svc.do(ctx, useTx, func(v *memoryView) error {
+		r := v.get(ID)
+		if r == nil || (r.DeletedAt != nil) != deleted {
+			return errors.Wrap(ErrNotFound, "Error executing get thunderbird - "+ID.String())
+		}
+		result = copyThunderbird(r)
+		return nil
+	})
+	return result, err
+}
+
+// Create a new thunderbird
+func (svc *memoryThunderbirdService) Create(ctx context.Context, input *Thunderbird) error {
+	_, err := svc.create(ctx, false, input, "", nil, 0)
+	return err
+}
+
+// CreateTx creates a new thunderbird within a tx from ctx
+func (svc *memoryThunderbirdService) CreateTx(ctx context.Context, input *Thunderbird) error {
+	_, err := svc.create(ctx, true, input, "", nil, 0)
+	return err
+}
+
+// CreateIdempotent creates a new thunderbird unless key was used by another create within the last ttl
+func (svc *memoryThunderbirdService) CreateIdempotent(ctx context.Context, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error) {
+	return svc.create(ctx, false, input, key, requestHash, ttl)
+}
+
+// CreateIdempotentTx creates a new thunderbird unless key was recently used, within a tx from ctx
+func (svc *memoryThunderbirdService) CreateIdempotentTx(ctx context.Context, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error) {
+	return svc.create(ctx, true, input, key, requestHash, ttl)
+}
+
+func (svc *memoryThunderbirdService) create(ctx context.Context, useTx bool, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error) {
+	errMsg := func() string { return "Error executing create thunderbird - " + input.ID.String() }
+
+	created := false
+	err := svc.do(ctx, useTx, func(v *memoryView) error {
+		now := svc.now()
+		if key != "" {
+			if mk, ok := v.key(key); ok && mk.ExpiresAt.After(now) {
+				if !bytes.Equal(mk.RequestHash, requestHash) {
+					return errors.Wrap(ErrIdempotencyKeyReused, errMsg()+": "+key)
+				}
+				r := v.get(mk.ID)
+				if r == nil {
+					return errors.Wrap(ErrNotFound, errMsg()+": "+mk.ID.String())
+				}
+				*input = *copyThunderbird(r)
+				return nil
+			}
+		}
+
+		if v.get(input.ID) != nil {
+			return errors.Wrap(ErrNotCreated, errMsg())
+		}
+
+		r := &Thunderbird{ID: input.ID, Name: input.Name, Version: 1, CreatedAt: now}
+		v.put(r)
+		if key != "" {
+			v.putKey(key, memoryKey{ID: input.ID, RequestHash: requestHash, ExpiresAt: now.Add(ttl)})
+		}
+		v.record(Change{Type: ChangeCreated, Thunderbird: copyThunderbird(r)})
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// PurgeIdempotencyKeys removes up to limit expired idempotency keys
+func (svc *memoryThunderbirdService) PurgeIdempotencyKeys(ctx context.Context, limit int) (int, error) {
+	svc.store.mu.Lock()
+	defer svc.store.mu.Unlock()
+
+	now := svc.now()
+	n := 0
+	for k, mk := range svc.store.keys {
+		if n == limit {
+			break
+		}
+		if !mk.ExpiresAt.After(now) {
+			delete(svc.store.keys, k)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Update updates a single thunderbird
+func (svc *memoryThunderbirdService) Update(ctx context.Context, input *Thunderbird) error {
+	return svc.update(ctx, false, input, ThunderbirdFields())
+}
+
+// UpdateTx updates a single thunderbird within a tx from ctx
+func (svc *memoryThunderbirdService) UpdateTx(ctx context.Context, input *Thunderbird) error {
+	return svc.update(ctx, true, input, ThunderbirdFields())
+}
+
+// UpdateFields updates only the named fields of a single thunderbird
+func (svc *memoryThunderbirdService) UpdateFields(ctx context.Context, input *Thunderbird, fields []string) error {
+	return svc.update(ctx, false, input, fields)
+}
+
+// UpdateFieldsTx updates only the named fields of a single thunderbird within a tx from ctx
+func (svc *memoryThunderbirdService) UpdateFieldsTx(ctx context.Context, input *Thunderbird, fields []string) error {
+	return svc.update(ctx, true, input, fields)
+}
+
+func (svc *memoryThunderbirdService) update(ctx context.Context, useTx bool, input *Thunderbird, fields []string) error {
+	errMsg := func() string { return "Error executing update thunderbird - " + input.ID.String() }
+
+	if len(fields) == 0 {
+		return errors.Wrap(ErrUnknownField, errMsg()+": no fields to update")
+	}
+	for _, f := range fields {
+		if _, ok := thunderbirdFields[f]; !ok {
+			return errors.Wrap(ErrUnknownField, errMsg()+": "+f)
+		}
+	}
+
+	return svc.do(ctx, useTx, func(v *memoryView) error {
+		r := v.get(input.ID)
+		if r == nil || r.DeletedAt != nil {
+			return errors.Wrap(ErrNoRowsAffected, errMsg())
+		}
+		if input.Version != 0 && input.Version != r.Version {
+			return errors.Wrap(ErrConflict, errMsg())
+		}
+
+		c := copyThunderbird(r)
+		for _, f := range fields {
+			switch f {
+			case "name":
+				c.Name = input.Name
+			}
+		}
+		c.Version++
+		v.put(c)
+
+		input.Version = c.Version
+		v.record(Change{Type: ChangeUpdated, Thunderbird: copyThunderbird(c)})
+		return nil
+	})
+}
+
+// Delete soft deletes a single thunderbird
+func (svc *memoryThunderbirdService) Delete(ctx context.Context, ID uuid.UUID) error {
+	return svc.delete(ctx, false, ID)
+}
+
+// DeleteTx soft deletes a single thunderbird within a tx from ctx
+func (svc *memoryThunderbirdService) DeleteTx(ctx context.Context, ID uuid.UUID) error {
+	return svc.delete(ctx, true, ID)
+}
+
+func (svc *memoryThunderbirdService) delete(ctx context.Context, useTx bool, ID uuid.UUID) error {
+	return svc.do(ctx, useTx, func(v *memoryView) error {
+		r := v.get(ID)
+		if r == nil || r.DeletedAt != nil {
+			return errors.Wrap(ErrNotFound, "Error executing delete thunderbird - "+ID.String())
+		}
+
+		c := copyThunderbird(r)
+		now := svc.now()
+		c.DeletedAt = &now
+		v.put(c)
+
+		v.record(Change{Type: ChangeDeleted, Thunderbird: &Thunderbird{ID: ID}})
+		return nil
+	})
+}
+
+// Restore clears the deletion of a single soft deleted thunderbird
+func (svc *memoryThunderbirdService) Restore(ctx context.Context, ID uuid.UUID) error {
+	return svc.restore(ctx, false, ID)
+}
+
+// RestoreTx clears the deletion of a single soft deleted thunderbird within a tx from ctx
+func (svc *memoryThunderbirdService) RestoreTx(ctx context.Context, ID uuid.UUID) error {
+	return svc.restore(ctx, true, ID)
+}
+
+func (svc *memoryThunderbirdService) restore(ctx context.Context, useTx bool, ID uuid.UUID) error {
+	return svc.do(ctx, useTx, func(v *memoryView) error {
+		r := v.get(ID)
+		if r == nil || r.DeletedAt == nil {
+			return errors.Wrap(ErrNotFound, "Error executing restore thunderbird - "+ID.String())
+		}
+
+		c := copyThunderbird(r)
+		c.DeletedAt = nil
+		v.put(c)
+
+		v.record(Change{Type: ChangeRestored, Thunderbird: &Thunderbird{ID: ID}})
+		return nil
+	})
+}
+
+// Purge permanently removes up to limit thunderbirds soft deleted before deletedBefore, oldest first
+func (svc *memoryThunderbirdService) Purge(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	n := 0
+	err := svc.do(ctx, false, func(v *memoryView) error {
+		expired := []*Thunderbird{}
+		for _, r := range v.all() {
+			if r.DeletedAt != nil && r.DeletedAt.Before(deletedBefore) {
+				expired = append(expired, r)
+			}
+		}
+		sort.Slice(expired, func(i, j int) bool { return expired[i].DeletedAt.Before(*expired[j].DeletedAt) })
+		if len(expired) > limit {
+			expired = expired[:limit]
+		}
+
+		for _, r := range expired {
+			v.purge(r.ID)
+			v.record(Change{Type: ChangePurged, Thunderbird: &Thunderbird{ID: r.ID}})
+		}
+		n = len(expired)
+		return nil
+	})
+	return n, err
+}
+
+// List returns a page of thunderbirds in the order and position given by params
+func (svc *memoryThunderbirdService) List(ctx context.Context, params *ListThunderbirdsParams) ([]*Thunderbird, error) {
+	return svc.list(ctx, false, params)
+}
+
+// ListTx returns a page of thunderbirds within a tx from ctx
+func (svc *memoryThunderbirdService) ListTx(ctx context.Context, params *ListThunderbirdsParams) ([]*Thunderbird, error) {
+	return svc.list(ctx, true, params)
+}
+
+func (svc *memoryThunderbirdService) list(ctx context.Context, useTx bool, params *ListThunderbirdsParams) ([]*Thunderbird, error) {
+	// compare orders two rows by (sort column, thunderbird_id) ascending
+	compare := func(a, b *Thunderbird) int {
+		if params.OrderBy == OrderByCreatedAt {
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				if a.CreatedAt.Before(b.CreatedAt) {
+					return -1
+				}
+				return 1
+			}
+		} else if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ID[:], b.ID[:])
+	}
+	// before reports whether a is listed ahead of b
+	before := func(a, b *Thunderbird) bool {
+		if params.Descending {
+			return compare(a, b) > 0
+		}
+		return compare(a, b) < 0
+	}
+
+	results := []*Thunderbird{}
+	err := svc.do(ctx, useTx, func(v *memoryView) error {
+		var after *Thunderbird
+		if params.After != nil {
+			after = &Thunderbird{ID: params.After.ID, Name: params.After.Name, CreatedAt: params.After.CreatedAt}
+		}
+
+		for _, r := range v.all() {
+			if r.DeletedAt != nil && !params.IncludeDeleted {
+				continue
+			}
+			if !strings.HasPrefix(r.Name, params.NamePrefix) {
+				continue
+			}
+			if after != nil && !before(after, r) {
+				continue
+			}
+			results = append(results, copyThunderbird(r))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool { return before(results[i], results[j]) })
+	if len(results) > params.Limit {
+		results = results[:params.Limit]
+	}
+	return results, nil
+}
+
+// Load fetches every thunderbird in IDs, missing and deleted IDs are omitted
+func (svc *memoryThunderbirdService) Load(ctx context.Context, IDs []uuid.UUID) ([]*Thunderbird, error) {
+	return svc.load(ctx, false, IDs)
+}
+
+// LoadTx fetches every thunderbird in IDs within a tx from ctx
+func (svc *memoryThunderbirdService) LoadTx(ctx context.Context, IDs []uuid.UUID) ([]*Thunderbird, error) {
+	return svc.load(ctx, true, IDs)
+}
+
+func (svc *memoryThunderbirdService) load(ctx context.Context, useTx bool, IDs []uuid.UUID) ([]*Thunderbird, error) {
+	results := []*Thunderbird{}
+	err := svc.do(ctx, useTx, func(v *memoryView) error {
+		seen := map[uuid.UUID]bool{}
+		for _, ID := range IDs {
+			r := v.get(ID)
+			if r == nil || r.DeletedAt != nil || seen[ID] {
+				continue
+			}
+			seen[ID] = true
+			results = append(results, copyThunderbird(r))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
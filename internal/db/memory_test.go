@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore_thunderbirds(t *testing.T) {
+	ctx := context.Background()
+
+	// ensures the full lifecycle honors not found and soft delete semantics
+	t.Run("Lifecycle", func(t *testing.T) {
+		s := NewMemoryStore()
+		svc := s.Thunderbirds()
+		ID := uuid.New()
+
+		err := svc.Create(ctx, &Thunderbird{ID: ID, Name: "Foobar"})
+		assert.NoError(t, err, "Expected no error")
+		assert.Error(t, svc.Create(ctx, &Thunderbird{ID: ID, Name: "Foobar"}), "Expected a duplicate ID to fail")
+
+		r, err := svc.Get(ctx, ID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, int64(1), r.Version, "Expected a new record at version 1")
+		assert.False(t, r.CreatedAt.IsZero(), "Expected created at to be set")
+
+		err = svc.Update(ctx, &Thunderbird{ID: ID, Name: "Bazqux", Version: 1})
+		assert.NoError(t, err, "Expected no error")
+		err = svc.Update(ctx, &Thunderbird{ID: ID, Name: "Stale", Version: 1})
+		assert.ErrorIs(t, err, ErrConflict, "Expected a stale version to conflict")
+
+		err = svc.Delete(ctx, ID)
+		assert.NoError(t, err, "Expected no error")
+		_, err = svc.Get(ctx, ID)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected a deleted record to be hidden")
+		assert.ErrorIs(t, svc.Delete(ctx, ID), ErrNotFound, "Expected a second delete to fail")
+		assert.ErrorIs(t, svc.Update(ctx, &Thunderbird{ID: ID, Name: "Bazqux"}), ErrNoRowsAffected, "Expected updates to skip deleted records")
+
+		deleted, err := svc.List(ctx, &ListThunderbirdsParams{Limit: 10, IncludeDeleted: true})
+		assert.NoError(t, err, "Expected no error")
+		if assert.Len(t, deleted, 1, "Expected the deleted record to be listed") {
+			assert.NotNil(t, deleted[0].DeletedAt, "Expected deleted at to be set")
+		}
+
+		err = svc.Restore(ctx, ID)
+		assert.NoError(t, err, "Expected no error")
+		r, err = svc.Get(ctx, ID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Bazqux", r.Name, "Expected the restored record")
+	})
+
+	// ensures writes in a tx are only visible within it until committed
+	t.Run("Transactions", func(t *testing.T) {
+		s := NewMemoryStore()
+		svc := s.Thunderbirds()
+		committed, rolledBack := uuid.New(), uuid.New()
+		sub, err := s.Subscribe(ctx, "")
+		assert.NoError(t, err, "Expected no error")
+		defer sub.Close()
+
+		txCtx, err := s.BeginTx(ctx)
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, svc.CreateTx(txCtx, &Thunderbird{ID: committed, Name: "Foobar"}), "Expected no error")
+
+		_, err = svc.GetTx(txCtx, committed)
+		assert.NoError(t, err, "Expected the write to be visible inside the tx")
+		_, err = svc.Get(ctx, committed)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected the write to be hidden outside the tx")
+		assert.Empty(t, receive(sub, 1), "Expected no change before commit")
+
+		assert.NoError(t, s.CommitTx(txCtx), "Expected no error")
+		_, err = svc.Get(ctx, committed)
+		assert.NoError(t, err, "Expected the write to be visible after commit")
+		assert.Len(t, receive(sub, 1), 1, "Expected the change to be published on commit")
+		assert.Error(t, s.CommitTx(txCtx), "Expected a finished tx to be rejected")
+
+		txCtx, err = s.BeginTx(ctx)
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, svc.CreateTx(txCtx, &Thunderbird{ID: rolledBack, Name: "Bazqux"}), "Expected no error")
+		assert.NoError(t, s.RollbackTx(txCtx), "Expected no error")
+		_, err = svc.Get(ctx, rolledBack)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected the write to be discarded")
+		assert.Empty(t, receive(sub, 1), "Expected no change from the rolled back tx")
+	})
+
+	// ensures concurrent commits of one tx apply it once and reject the rest
+	t.Run("Concurrent commit", func(t *testing.T) {
+		s := NewMemoryStore()
+		txCtx, err := s.BeginTx(ctx)
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, s.Thunderbirds().CreateTx(txCtx, &Thunderbird{ID: uuid.New(), Name: "Foobar"}), "Expected no error")
+
+		errs := make(chan error, 8)
+		for i := 0; i < cap(errs); i++ {
+			go func() { errs <- s.CommitTx(txCtx) }()
+		}
+		committed := 0
+		for i := 0; i < cap(errs); i++ {
+			if <-errs == nil {
+				committed++
+			}
+		}
+		assert.Equal(t, 1, committed, "Expected the tx to be committed once")
+	})
+
+	// ensures a replayed idempotency key resolves to the original record until it expires
+	t.Run("Idempotency keys", func(t *testing.T) {
+		s := NewMemoryStore()
+		now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		s.now = func() time.Time { return now }
+		svc := s.Thunderbirds()
+
+		first := &Thunderbird{ID: uuid.New(), Name: "Foobar"}
+		created, err := svc.CreateIdempotent(ctx, first, "req-1", []byte("Foobar"), time.Hour)
+		assert.NoError(t, err, "Expected no error")
+		assert.True(t, created, "Expected the first create to insert")
+
+		replay := &Thunderbird{ID: uuid.New(), Name: "Foobar"}
+		created, err = svc.CreateIdempotent(ctx, replay, "req-1", []byte("Foobar"), time.Hour)
+		assert.NoError(t, err, "Expected no error")
+		assert.False(t, created, "Expected the replay not to insert")
+		assert.Equal(t, first.ID, replay.ID, "Expected the original ID")
+		assert.Equal(t, int64(1), replay.Version, "Expected the original thunderbird to be read")
+
+		_, err = svc.CreateIdempotent(ctx, &Thunderbird{ID: uuid.New(), Name: "Bazqux"}, "req-1", []byte("Bazqux"), time.Hour)
+		assert.ErrorIs(t, err, ErrIdempotencyKeyReused, "Expected a different request to be rejected")
+
+		now = now.Add(2 * time.Hour)
+		n, err := svc.PurgeIdempotencyKeys(ctx, 10)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 1, n, "Expected the expired key to be purged")
+	})
+
+	// ensures old soft deleted records are purged oldest first
+	t.Run("Purge", func(t *testing.T) {
+		s := NewMemoryStore()
+		old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		recent := old.Add(48 * time.Hour)
+		oldID, recentID := uuid.New(), uuid.New()
+		s.Seed(
+			&Thunderbird{ID: oldID, Name: "old", DeletedAt: &old},
+			&Thunderbird{ID: recentID, Name: "recent", DeletedAt: &recent},
+		)
+
+		n, err := s.Thunderbirds().Purge(ctx, old.Add(24*time.Hour), 10)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 1, n, "Expected only the old record to be purged")
+
+		r, err := s.Thunderbirds().List(ctx, &ListThunderbirdsParams{Limit: 10, IncludeDeleted: true})
+		assert.NoError(t, err, "Expected no error")
+		if assert.Len(t, r, 1, "Expected one record to remain") {
+			assert.Equal(t, recentID, r[0].ID, "Expected the recent record to remain")
+		}
+	})
+}
@@ -0,0 +1,10 @@
+package db
+
+import "embed"
+
+// Migrations holds the schema migrations in golang-migrate's naming scheme, under
+// the migrations directory. They are compiled in so the schema a binary migrates
+// to always matches the statements it prepares.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
@@ -1,7 +1 @@
-USE products;
-
-DROP TABLE IF EXISTS products;
-
-DROP TABLE IF EXISTS categories;
-
-DROP SCHEMA IF EXISTS products;
+DROP TABLE IF EXISTS thunderbirds;
@@ -0,0 +1,3 @@
+ALTER TABLE thunderbirds
+  DROP INDEX ix__thunderbirds__name,
+  DROP INDEX ix__thunderbirds__created_at;
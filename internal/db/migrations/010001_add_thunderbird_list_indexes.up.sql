@@ -0,0 +1,7 @@
+--
+-- Keyset pagination indexes for list-thunderbirds-* statements. Each ordering
+-- is covered by (sort column, thunderbird_id) so a page is a single range scan.
+--
+ALTER TABLE thunderbirds
+  ADD INDEX ix__thunderbirds__name (name, thunderbird_id),
+  ADD INDEX ix__thunderbirds__created_at (created_at, thunderbird_id);
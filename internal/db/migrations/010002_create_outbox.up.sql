@@ -0,0 +1,16 @@
+--
+-- Transactional outbox. Each thunderbird mutation writes an event row in the
+-- same transaction as the mutation, and the relay publishes then deletes them
+-- in event_id order.
+--
+CREATE TABLE outbox (
+  event_id        BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  aggregate_type  varchar(64) NOT NULL,
+  aggregate_id    BINARY(16) NOT NULL,
+  event_type      varchar(64) NOT NULL,
+  payload         JSON NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+)
+ENGINE=InnoDB
+DEFAULT CHARSET=utf8mb4
+COMMENT='Mutation events waiting to be published to the analytics pipeline';
@@ -0,0 +1,2 @@
+ALTER TABLE thunderbirds
+  DROP COLUMN version;
@@ -0,0 +1,6 @@
+--
+-- Row version for optimistic concurrency. Every update bumps it, and callers may
+-- make an update conditional on the version they last read.
+--
+ALTER TABLE thunderbirds
+  ADD COLUMN version INT UNSIGNED NOT NULL DEFAULT 1 AFTER name;
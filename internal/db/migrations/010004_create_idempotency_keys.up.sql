@@ -0,0 +1,15 @@
+--
+-- Client supplied request ids for CreateThunderbird. A key maps to the
+-- thunderbird its first request created until expires_at, so a retried create
+-- returns that thunderbird instead of inserting another.
+--
+CREATE TABLE idempotency_keys (
+  idempotency_key varchar(128) NOT NULL PRIMARY KEY,
+  thunderbird_id  BINARY(16) NOT NULL,
+  created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at      DATETIME NOT NULL,
+  INDEX ix__idempotency_keys__expires_at (expires_at)
+)
+ENGINE=InnoDB
+DEFAULT CHARSET=utf8mb4
+COMMENT='Request ids of recent thunderbird creates for safe retries';
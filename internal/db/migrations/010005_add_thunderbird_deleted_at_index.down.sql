@@ -0,0 +1,2 @@
+ALTER TABLE thunderbirds
+  DROP INDEX ix__thunderbirds__deleted_at;
@@ -0,0 +1,6 @@
+--
+-- Supports list-purgeable-thunderbirds, which range scans deleted_at oldest
+-- first instead of reading every row.
+--
+ALTER TABLE thunderbirds
+  ADD INDEX ix__thunderbirds__deleted_at (deleted_at);
@@ -0,0 +1,7 @@
+--
+-- A hash of the create request a key was first used with, so a replay of the
+-- key with a different request is rejected rather than answered with the
+-- thunderbird of the first. Keys claimed before this column are not checked.
+--
+ALTER TABLE idempotency_keys
+  ADD COLUMN request_hash BINARY(32) NULL AFTER thunderbird_id;
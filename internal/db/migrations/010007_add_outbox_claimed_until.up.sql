@@ -0,0 +1,7 @@
+--
+-- A relay claims the events it is about to publish by setting claimed_until and
+-- committing, so no row lock is held while it calls the sink. Other relays skip
+-- a claimed event until the claim runs out.
+--
+ALTER TABLE outbox
+  ADD COLUMN claimed_until DATETIME NULL AFTER payload;
@@ -0,0 +1,6 @@
+-- without published_at the relay would publish the retained events again
+DELETE FROM outbox WHERE published_at IS NOT NULL;
+
+ALTER TABLE outbox
+  DROP INDEX ix__outbox__published_at,
+  DROP COLUMN published_at;
@@ -0,0 +1,9 @@
+--
+-- Published events are marked rather than deleted and kept for a day, so the
+-- watch stream can read changes from the outbox and resume from any event_id
+-- in that window. The index serves both the relay, which reads unpublished
+-- events in event_id order, and the purge of old published ones.
+--
+ALTER TABLE outbox
+  ADD COLUMN published_at DATETIME NULL AFTER claimed_until,
+  ADD INDEX ix__outbox__published_at (published_at, event_id);
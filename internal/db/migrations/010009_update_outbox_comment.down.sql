@@ -0,0 +1,2 @@
+ALTER TABLE outbox
+  COMMENT = 'Mutation events waiting to be published to the analytics pipeline';
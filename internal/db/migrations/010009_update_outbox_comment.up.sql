@@ -0,0 +1,8 @@
+--
+-- 010002 describes outbox rows as deleted once published. Since 010007 and
+-- 010008 the relay claims events, publishes them and marks them published, and
+-- published events are kept for a day for the change feed before the relay
+-- purges them. The table comment is brought in line.
+--
+ALTER TABLE outbox
+  COMMENT = 'Mutation events, published to the analytics pipeline and kept for the change feed for a day after';
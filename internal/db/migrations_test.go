@@ -0,0 +1,53 @@
+package db
+
+import (
+	"io/fs"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	// ensures the embedded migrations parse as a migrate source
+	t.Run("Valid source", func(t *testing.T) {
+		src, err := iofs.New(Migrations, "migrations")
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "source setup failed")
+		}
+		defer src.Close()
+
+		version, err := src.First()
+		assert.NoError(t, err, "Expected a first migration")
+		assert.Equal(t, uint(10000), version, "Expected the initial migration first")
+	})
+
+	// ensures every up migration can be rolled back
+	t.Run("Paired up and down", func(t *testing.T) {
+		files, err := fs.Glob(Migrations, "migrations/*.up.sql")
+		assert.NoError(t, err, "Expected no error")
+		assert.NotEmpty(t, files, "Expected migrations to be embedded")
+
+		for _, f := range files {
+			down := strings.TrimSuffix(f, ".up.sql") + ".down.sql"
+			_, err := fs.Stat(Migrations, down)
+			assert.NoError(t, err, "Expected a down migration for "+f)
+		}
+	})
+
+	// ensures migrations run against the connection's schema instead of naming one
+	t.Run("No hard coded schema", func(t *testing.T) {
+		schemaStmt := regexp.MustCompile(`(?im)^\s*(USE\s|CREATE\s+(SCHEMA|DATABASE)|DROP\s+(SCHEMA|DATABASE))`)
+
+		files, err := fs.Glob(Migrations, "migrations/*.sql")
+		assert.NoError(t, err, "Expected no error")
+
+		for _, f := range files {
+			b, err := fs.ReadFile(Migrations, f)
+			assert.NoError(t, err, "Expected no error")
+			assert.False(t, schemaStmt.Match(b), "Expected no schema statements in "+f)
+		}
+	})
+}
@@ -0,0 +1,347 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+)
+
+// outboxClaimTTL is how long a relay has to publish the events it claimed before
+// another relay may claim them
+const outboxClaimTTL = time.Minute
+
+// Outbox event types for thunderbird mutations
+const (
+	ThunderbirdAggregate    = "thunderbird"
+	ThunderbirdCreatedType  = "thunderbird.created"
+	ThunderbirdUpdatedType  = "thunderbird.updated"
+	ThunderbirdDeletedType  = "thunderbird.deleted"
+	ThunderbirdRestoredType = "thunderbird.restored"
+	ThunderbirdPurgedType   = "thunderbird.purged"
+)
+
+// OutboxEvent is a row in the outbox table. It is written in the same tx as the
+// mutation it describes, marked once it has been published, and removed
+// changeRetention after that.
+type OutboxEvent struct {
+	ID            int64
+	AggregateType string
+	AggregateID   uuid.UUID
+	Type          string
+	Payload       json.RawMessage
+	CreatedAt     time.Time
+}
+
+// OutboxStore is the API for relaying outbox events to a downstream sink
+type OutboxStore interface {
+	// Relay claims up to limit of the oldest unpublished events and passes them to
+	// publish. If publish succeeds the events are marked published, otherwise they
+	// are left for a later attempt. Returns the number of events published.
+	//
+	// Delivery is at least once: an event published by a relay that then fails to
+	// mark it, or whose claim runs out while it publishes, is published again.
+	// Consumers must dedupe on OutboxEvent.ID, the event_id.
+	Relay(ctx context.Context, limit int, publish func(context.Context, []*OutboxEvent) error) (int, error)
+	// Purge removes up to limit events published more than changeRetention ago,
+	// after which the change feed can no longer resume from them. Returns the
+	// number of events removed.
+	Purge(ctx context.Context, limit int) (int, error)
+}
+
+// outboxService provides an API for interacting with the outbox table
+type outboxService struct {
+	db    *sql.DB
+	stmts *stmtRegistry
+	hooks *queryHooks
+}
+
+var (
+	_ OutboxStore  = &outboxService{}
+	_ ChangeSource = &outboxService{}
+)
+
+// newOutboxService builds an outboxService from a db connection, its prepared statements
+// and the hooks run around statements
+func newOutboxService(db *sql.DB, stmts *stmtRegistry, hooks *queryHooks) *outboxService {
+	return &outboxService{
+		db:    db,
+		stmts: stmts,
+		hooks: hooks,
+	}
+}
+
+// thunderbirdPayload is the outbox payload of a thunderbird mutation
+type thunderbirdPayload struct {
+	ID        string     `json:"thunderbird_id"`
+	Name      string     `json:"name,omitempty"`
+	Version   int64      `json:"version,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// changeTypes maps outbox event types to the change they record
+var changeTypes = map[string]ChangeType{
+	ThunderbirdCreatedType:  ChangeCreated,
+	ThunderbirdUpdatedType:  ChangeUpdated,
+	ThunderbirdDeletedType:  ChangeDeleted,
+	ThunderbirdRestoredType: ChangeRestored,
+	ThunderbirdPurgedType:   ChangePurged,
+}
+
+// newThunderbirdEvent builds the outbox event for a thunderbird mutation
+func newThunderbirdEvent(eventType string, m *Thunderbird) (*OutboxEvent, error) {
+	p := thunderbirdPayload{
+		ID:      m.ID.String(),
+		Name:    m.Name,
+		Version: m.Version,
+	}
+	if !m.CreatedAt.IsZero() {
+		p.CreatedAt = &m.CreatedAt
+	}
+
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &OutboxEvent{
+		AggregateType: ThunderbirdAggregate,
+		AggregateID:   m.ID,
+		Type:          eventType,
+		Payload:       payload,
+	}, nil
+}
+
+// addOutboxEvent writes an event to the outbox within tx
+func addOutboxEvent(ctx context.Context, tx *sql.Tx, stmts *stmtRegistry, hooks *queryHooks, e *OutboxEvent) error {
+	errMsg := func() string { return "Error executing create outbox event - " + e.Type }
+
+	stmt, err := stmts.tx(tx, createOutboxEventStmt)
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	args := []interface{}{e.AggregateType, e.AggregateID, e.Type, []byte(e.Payload)}
+	qctx, done := hooks.start(ctx, createOutboxEventStmt, args...)
+	result, err := stmt.ExecContext(qctx, args...)
+	done(result, err)
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+	return nil
+}
+
+// Relay claims the oldest unpublished events in a short tx, publishes them outside
+// of it and then marks them published. No row lock is held while publish runs, so a slow
+// sink does not block writers or other relays.
+func (svc *outboxService) Relay(ctx context.Context, limit int, publish func(context.Context, []*OutboxEvent) error) (int, error) {
+	errMsg := func() string { return "Error executing relay outbox events - " + fmt.Sprint(limit) }
+
+	events, err := svc.claim(ctx, limit)
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg())
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	// the claim runs out after outboxClaimTTL, so stop publishing before another
+	// relay may take the events over
+	pctx, cancel := context.WithTimeout(ctx, outboxClaimTTL)
+	err = publish(pctx, events)
+	cancel()
+	if err != nil {
+		svc.release(ctx, events)
+		return 0, errors.Wrap(err, errMsg())
+	}
+
+	// an event that is not marked is published again once its claim runs out
+	for _, e := range events {
+		if err = svc.exec(ctx, publishOutboxEventStmt, e.ID); err != nil {
+			return 0, errors.Wrap(err, errMsg())
+		}
+	}
+
+	return len(events), nil
+}
+
+// claim locks up to limit of the oldest unpublished and unclaimed events, claims them for
+// outboxClaimTTL and commits, returning the claimed events
+func (svc *outboxService) claim(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := svc.stmts.tx(tx, listOutboxEventsStmt)
+	if err != nil {
+		return nil, err
+	}
+
+	qctx, done := svc.hooks.start(ctx, listOutboxEventsStmt, limit)
+	rows, err := stmt.QueryContext(qctx, limit)
+	done(nil, err)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		e := OutboxEvent{}
+		var payload []byte
+		if err = rows.Scan(&e.ID, &e.AggregateType, &e.AggregateID, &e.Type, &payload, &e.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, &e)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(events) == 0 {
+		return events, nil
+	}
+
+	stmt, err = svc.stmts.tx(tx, claimOutboxEventStmt)
+	if err != nil {
+		return nil, err
+	}
+	ttl := int64(outboxClaimTTL / time.Second)
+	for _, e := range events {
+		qctx, done := svc.hooks.start(ctx, claimOutboxEventStmt, ttl, e.ID)
+		result, err := stmt.ExecContext(qctx, ttl, e.ID)
+		done(result, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// release clears the claim on events that failed to publish so the next relay
+// retries them without waiting for the claim to run out. A failure only delays
+// the retry, so it is not reported.
+func (svc *outboxService) release(ctx context.Context, events []*OutboxEvent) {
+	for _, e := range events {
+		if err := svc.exec(ctx, releaseOutboxEventStmt, e.ID); err != nil {
+			return
+		}
+	}
+}
+
+// Purge removes up to limit events published more than changeRetention ago
+func (svc *outboxService) Purge(ctx context.Context, limit int) (int, error) {
+	errMsg := func() string { return "Error executing purge outbox events - " + fmt.Sprint(limit) }
+
+	stmt, err := svc.stmts.get(purgeOutboxEventsStmt)
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg())
+	}
+
+	args := []interface{}{int64(changeRetention / time.Second), limit}
+	qctx, done := svc.hooks.start(ctx, purgeOutboxEventsStmt, args...)
+	result, err := stmt.ExecContext(qctx, args...)
+	done(result, err)
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg())
+	}
+
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg())
+	}
+	return int(rowCount), nil
+}
+
+// LastChangeID returns the event_id of the newest outbox event
+func (svc *outboxService) LastChangeID(ctx context.Context) (int64, error) {
+	errMsg := func() string { return "Error executing get last change id" }
+
+	stmt, err := svc.stmts.get(getLastChangeIDStmt)
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg())
+	}
+
+	qctx, done := svc.hooks.start(ctx, getLastChangeIDStmt)
+	row := stmt.QueryRowContext(qctx)
+	done(nil, row.Err())
+
+	var id int64
+	if err = row.Scan(&id); err != nil {
+		return 0, errors.Wrap(err, errMsg())
+	}
+	return id, nil
+}
+
+// ChangesAfter returns up to limit of the outbox events after the event_id after,
+// published or not, as changes
+func (svc *outboxService) ChangesAfter(ctx context.Context, after int64, limit int) ([]Change, error) {
+	errMsg := func() string { return "Error executing list changes - " + fmt.Sprint(after) }
+
+	stmt, err := svc.stmts.get(listChangesStmt)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	qctx, done := svc.hooks.start(ctx, listChangesStmt, after, limit)
+	rows, err := stmt.QueryContext(qctx, after, limit)
+	done(nil, err)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+	defer rows.Close()
+
+	changes := []Change{}
+	for rows.Next() {
+		var (
+			c         Change
+			ID        uuid.UUID
+			eventType string
+			payload   []byte
+		)
+		if err = rows.Scan(&c.id, &ID, &eventType, &payload, &c.At); err != nil {
+			return nil, errors.Wrap(err, errMsg())
+		}
+
+		p := thunderbirdPayload{}
+		if err = json.Unmarshal(payload, &p); err != nil {
+			return nil, errors.Wrap(err, errMsg())
+		}
+		c.Type = changeTypes[eventType]
+		c.Thunderbird = &Thunderbird{ID: ID, Name: p.Name, Version: p.Version}
+		if p.CreatedAt != nil {
+			c.Thunderbird.CreatedAt = *p.CreatedAt
+		}
+		changes = append(changes, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	return changes, nil
+}
+
+// exec runs a prepared statement outside of a tx
+func (svc *outboxService) exec(ctx context.Context, name stmtName, args ...interface{}) error {
+	stmt, err := svc.stmts.get(name)
+	if err != nil {
+		return err
+	}
+
+	qctx, done := svc.hooks.start(ctx, name, args...)
+	result, err := stmt.ExecContext(qctx, args...)
+	done(result, err)
+	return err
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxService_Relay(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"list-outbox-events":   "SELECT outbox",
+		"claim-outbox-event":   "UPDATE outbox SET claimed_until = DATE_ADD",
+		"release-outbox-event": "UPDATE outbox SET claimed_until = NULL",
+		"publish-outbox-event": "UPDATE outbox SET published_at",
+	}
+	rows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"event_id", "aggregate_type", "aggregate_id", "event_type", "payload", "created_at"}).
+			AddRow(1, "thunderbird", thunderbirdID, "thunderbird.created", []byte(`{"thunderbird_id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65"}`), createdAt).
+			AddRow(2, "thunderbird", thunderbirdID, "thunderbird.deleted", []byte(`{"thunderbird_id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65"}`), createdAt)
+	}
+
+	// ensures events are claimed in a committed tx before they are published and
+	// marked published after
+	t.Run("Successful publish", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").WithArgs(10).WillReturnRows(rows())
+		mock.ExpectExec("UPDATE outbox SET claimed_until").WithArgs(60, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE outbox SET claimed_until").WithArgs(60, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		published := []*OutboxEvent{}
+		n, err := store.Outbox.Relay(context.Background(), 10, func(ctx context.Context, events []*OutboxEvent) error {
+			assert.NoError(t, mock.ExpectationsWereMet(), "Expected the claim to be committed before publishing")
+			mock.ExpectExec("UPDATE outbox SET published_at").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE outbox SET published_at").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			published = append(published, events...)
+			return nil
+		})
+		assert.NoError(t, err, "Expecting no relay error")
+		assert.Equal(t, 2, n, "Expected both events to be relayed")
+
+		if assert.Len(t, published, 2, "Expected both events to be published") {
+			assert.Equal(t, int64(1), published[0].ID, "Expected events in order")
+			assert.Equal(t, "thunderbird.created", published[0].Type, "Expected event type to be scanned")
+			assert.Equal(t, thunderbirdID, published[0].AggregateID, "Expected aggregate ID to be scanned")
+		}
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures nothing is published when no event is unclaimed
+	t.Run("Nothing to relay", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").WithArgs(10).WillReturnRows(sqlmock.NewRows([]string{"event_id", "aggregate_type", "aggregate_id", "event_type", "payload", "created_at"}))
+		mock.ExpectRollback()
+
+		n, err := store.Outbox.Relay(context.Background(), 10, func(ctx context.Context, events []*OutboxEvent) error {
+			assert.Fail(t, "Expected publish not to be called")
+			return nil
+		})
+		assert.NoError(t, err, "Expecting no relay error")
+		assert.Equal(t, 0, n, "Expected no events to be relayed")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures the claim is released when publishing fails so the events are retried
+	t.Run("Failed publish", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").WithArgs(10).WillReturnRows(rows())
+		mock.ExpectExec("UPDATE outbox SET claimed_until").WithArgs(60, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE outbox SET claimed_until").WithArgs(60, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("UPDATE outbox SET claimed_until = NULL").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE outbox SET claimed_until = NULL").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		n, err := store.Outbox.Relay(context.Background(), 10, func(ctx context.Context, events []*OutboxEvent) error {
+			return errors.New("sink unavailable")
+		})
+		assert.Error(t, err, "Expected the publish error")
+		assert.Equal(t, 0, n, "Expected no events to be relayed")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures an event that fails to be marked is reported and left claimed
+	t.Run("Failed mark", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT outbox").WithArgs(10).WillReturnRows(rows())
+		mock.ExpectExec("UPDATE outbox SET claimed_until").WithArgs(60, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE outbox SET claimed_until").WithArgs(60, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("UPDATE outbox SET published_at").WithArgs(1).WillReturnError(errors.New("connection lost"))
+
+		n, err := store.Outbox.Relay(context.Background(), 10, func(ctx context.Context, events []*OutboxEvent) error {
+			return nil
+		})
+		assert.Error(t, err, "Expected the mark error")
+		assert.Equal(t, 0, n, "Expected no events to be reported relayed")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestOutboxService_Purge(t *testing.T) {
+	// ensures events published longer ago than the change retention are removed
+	store, mock, err := NewTestDB(map[string]string{
+		"purge-outbox-events": "DELETE outbox",
+	})
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+
+	mock.ExpectExec("DELETE outbox").WithArgs(86400, 10).WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := store.Outbox.Purge(context.Background(), 10)
+	assert.NoError(t, err, "Expecting no purge error")
+	assert.Equal(t, 3, n, "Expected the removed events to be counted")
+
+	err = mock.ExpectationsWereMet()
+	assert.NoError(t, err, "Expecting all mock conditions to be met")
+}
+
+func TestOutboxService_ChangeSource(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"get-last-change-id": "SELECT MAX outbox",
+		"list-changes":       "SELECT changes outbox",
+	}
+
+	// ensures the newest event_id is the position of a new subscription
+	t.Run("Last change ID", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT MAX outbox").WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow(42))
+
+		id, err := store.Outbox.(*outboxService).LastChangeID(context.Background())
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, int64(42), id, "Expected the newest event_id")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures events after the given event_id are read back as changes
+	t.Run("Changes after", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT changes outbox").WithArgs(41, 10).WillReturnRows(
+			sqlmock.NewRows([]string{"event_id", "aggregate_id", "event_type", "payload", "created_at"}).
+				AddRow(42, thunderbirdID, "thunderbird.updated", []byte(`{"thunderbird_id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Foobar","version":3,"created_at":"2020-01-02T03:04:05Z"}`), createdAt).
+				AddRow(43, thunderbirdID, "thunderbird.deleted", []byte(`{"thunderbird_id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65"}`), createdAt))
+
+		changes, err := store.Outbox.(*outboxService).ChangesAfter(context.Background(), 41, 10)
+		assert.NoError(t, err, "Expecting no query error")
+
+		if assert.Len(t, changes, 2, "Expected both events") {
+			assert.Equal(t, int64(42), changes[0].id, "Expected the event_id as the change ID")
+			assert.Equal(t, ChangeUpdated, changes[0].Type, "Expected an update change")
+			assert.Equal(t, &Thunderbird{ID: thunderbirdID, Name: "Foobar", Version: 3, CreatedAt: createdAt}, changes[0].Thunderbird, "Expected the payload to be decoded")
+			assert.Equal(t, createdAt, changes[0].At, "Expected the event time")
+			assert.Equal(t, ChangeDeleted, changes[1].Type, "Expected a delete change")
+			assert.Equal(t, &Thunderbird{ID: thunderbirdID}, changes[1].Thunderbird, "Expected only the ID")
+		}
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/caring/go-packages/pkg/errors"
+)
+
+// ErrUnknownStatement is returned when a statement is used that the store was not
+// created with
+var ErrUnknownStatement = errors.New("Unknown statement")
+
+// StmtError is a statement that failed to prepare. Required is set when it
+// failed on the primary and is not one of the optionalStatements.
+type StmtError struct {
+	Name     string
+	Err      error
+	Replica  bool
+	Required bool
+}
+
+// PrepareError reports every statement that failed to prepare, those on the
+// primary first, each in order of name, and the error reaching the read replica
+// if it could not be
+type PrepareError struct {
+	Failures   []StmtError
+	ReplicaErr error
+}
+
+func (e *PrepareError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		on := ""
+		if f.Replica {
+			on = " (read replica)"
+		}
+		failures[i] = f.Name + on + ": " + f.Err.Error()
+	}
+	msg := fmt.Sprintf("%d statements failed to prepare - %s", len(e.Failures), strings.Join(failures, "; "))
+	if e.ReplicaErr != nil {
+		msg += " - read replica unreachable: " + e.ReplicaErr.Error()
+	}
+	return msg
+}
+
+// Required reports whether a statement the store cannot run without failed
+func (e *PrepareError) Required() bool {
+	for _, f := range e.Failures {
+		if f.Required {
+			return true
+		}
+	}
+	return false
+}
+
+// stmtRegistry holds the statements prepared on a db. A statement that is not
+// prepared, because it failed to or was dropped as stale, is prepared when it is
+// next used.
+type stmtRegistry struct {
+	db      *sql.DB
+	queries map[stmtName]string
+
+	mu    sync.RWMutex
+	stmts map[stmtName]*sql.Stmt
+}
+
+// newStmtRegistry creates a registry for queries on db, none of them prepared yet
+func newStmtRegistry(db *sql.DB, queries map[stmtName]string) *stmtRegistry {
+	return &stmtRegistry{
+		db:      db,
+		queries: queries,
+		stmts:   map[stmtName]*sql.Stmt{},
+	}
+}
+
+// prepare prepares every query in order of name, carrying on past failures, and
+// returns each that failed
+func (r *stmtRegistry) prepare() []StmtError {
+	var failures []StmtError
+	for _, name := range r.names() {
+		stmt, err := r.db.Prepare(r.queries[name])
+		if err != nil {
+			failures = append(failures, StmtError{Name: name.String(), Err: err, Required: !optionalStatements[name]})
+			continue
+		}
+
+		r.mu.Lock()
+		r.stmts[name] = stmt
+		r.mu.Unlock()
+	}
+	return failures
+}
+
+// get returns the prepared statement name, preparing it first if it is not yet.
+// The error wraps ErrUnknownStatement if the registry has no query by that name.
+func (r *stmtRegistry) get(name stmtName) (*sql.Stmt, error) {
+	r.mu.RLock()
+	stmt, ok := r.stmts[name]
+	r.mu.RUnlock()
+	if ok {
+		return stmt, nil
+	}
+
+	query, ok := r.queries[name]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownStatement, name.String())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if stmt, ok = r.stmts[name]; ok {
+		return stmt, nil
+	}
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error preparing statement - "+name.String())
+	}
+	r.stmts[name] = stmt
+	return stmt, nil
+}
+
+// tx returns the prepared statement name bound to tx
+func (r *stmtRegistry) tx(tx *sql.Tx, name stmtName) (*sql.Stmt, error) {
+	stmt, err := r.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Stmt(stmt), nil
+}
+
+// invalidate drops the prepared statement name so it is prepared again when it is
+// next used. The dropped statement is not closed, as calls that already hold it
+// may still be running it.
+func (r *stmtRegistry) invalidate(name stmtName) {
+	r.mu.Lock()
+	delete(r.stmts, name)
+	r.mu.Unlock()
+}
+
+// names returns the names of the registry's queries in sorted order
+func (r *stmtRegistry) names() []stmtName {
+	return stmtNames(r.queries)
+}
+
+// stmtNames returns the names of a statement map in sorted order
+func stmtNames(stmts map[stmtName]string) []stmtName {
+	names := make([]stmtName, 0, len(stmts))
+	for k := range stmts {
+		names = append(names, k)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].String() < names[j].String() })
+	return names
+}
+
+// EnableReprepare drops a statement whenever the server reports it as no longer
+// prepared, e.g. after a failover to a server that never saw it, so it is prepared
+// again by the retry of the operation that ran it. It must be called before the
+// store is used.
+func (s *Store) EnableReprepare() {
+	s.AddQueryHook(func(ctx context.Context, q Query) (context.Context, func(QueryResult)) {
+		return ctx, func(r QueryResult) {
+			if !isStaleStmt(r.Err) {
+				return
+			}
+			s.stmts.invalidate(q.stmt)
+			if s.replica != nil {
+				s.replica.stmts.invalidate(q.stmt)
+			}
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStmtRegistry(t *testing.T) {
+	queries := map[stmtName]string{
+		createThunderbirdStmt: "INSERT thunderbirds",
+		deleteThunderbirdStmt: "UPDATE thunderbirds",
+		getThunderbirdStmt:    "SELECT thunderbirds",
+	}
+
+	// ensures every statement that fails to prepare is reported, and is prepared
+	// when it is next used
+	t.Run("Prepare", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		noTable := errors.New("table thunderbirds doesn't exist")
+		mock.ExpectPrepare("INSERT thunderbirds").WillReturnError(noTable)
+		mock.ExpectPrepare("UPDATE thunderbirds")
+		mock.ExpectPrepare("SELECT thunderbirds").WillReturnError(noTable)
+		mock.ExpectPrepare("INSERT thunderbirds")
+
+		r := newStmtRegistry(db, queries)
+		failures := r.prepare()
+		assert.Equal(t, []StmtError{
+			{Name: "create-thunderbird", Err: noTable, Required: true},
+			{Name: "get-thunderbird", Err: noTable, Required: true},
+		}, failures, "Expected each failed statement in order")
+
+		err = &PrepareError{Failures: failures}
+		assert.Contains(t, err.Error(), "2 statements failed to prepare", "Expected the failures to be counted")
+
+		stmt, err := r.get(createThunderbirdStmt)
+		assert.NoError(t, err, "Expected the statement to be prepared on use")
+		assert.NotNil(t, stmt, "Expected the statement")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures only failures on the primary of statements the store cannot run
+	// without are required
+	t.Run("Required", func(t *testing.T) {
+		err := &PrepareError{Failures: []StmtError{
+			{Name: "purge-thunderbird", Err: errors.New("no table")},
+			{Name: "get-thunderbird", Err: errors.New("no table"), Replica: true},
+		}}
+		assert.False(t, err.Required(), "Expected optional and replica failures not to be required")
+
+		err.Failures = append(err.Failures, StmtError{Name: "get-thunderbird", Err: errors.New("no table"), Required: true})
+		assert.True(t, err.Required(), "Expected a required failure")
+	})
+
+	// ensures a statement the registry was not created with is an error rather than nil
+	t.Run("Unknown statement", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		stmt, err := newStmtRegistry(db, queries).get(lockThunderbirdStmt)
+		assert.ErrorIs(t, err, ErrUnknownStatement, "Expected unknown statement")
+		assert.Nil(t, stmt, "Expected no statement")
+	})
+}
+
+func TestStmtName(t *testing.T) {
+	// ensures every statement has a distinct name to report
+	t.Run("Names", func(t *testing.T) {
+		seen := map[string]bool{}
+		for name := range stmtNameText {
+			text := stmtName(name).String()
+			assert.NotEmpty(t, text, "Expected a name for statement %d", name)
+			assert.False(t, seen[text], "Expected "+text+" to be named once")
+			seen[text] = true
+		}
+		for name := range statements {
+			assert.NotEmpty(t, name.String(), "Expected every statement to be named")
+		}
+	})
+}
+
+func TestStore_Statements(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// ensures a store missing a statement returns an error where it would have panicked
+	t.Run("Missing statement", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{
+			"create-outbox-event": "INSERT outbox",
+		})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		_, err = store.Thunderbird.Get(context.Background(), thunderbirdID)
+		assert.ErrorIs(t, err, ErrUnknownStatement, "Expected unknown statement")
+
+		err = store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.ErrorIs(t, err, ErrUnknownStatement, "Expected unknown statement")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures a statement the server no longer has prepared is prepared again and
+	// the read retried
+	t.Run("Reprepare", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{
+			"get-thunderbird": "SELECT thunderbirds",
+		})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		store.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+		store.EnableReprepare()
+
+		mock.ExpectQuery("SELECT thunderbirds").
+			WillReturnError(&mysql.MySQLError{Number: 1243, Message: "Unknown prepared statement handler"})
+		mock.ExpectPrepare("SELECT thunderbirds")
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}).
+				AddRow(thunderbirdID, "Thunderbird", 1, createdAt, nil))
+
+		r, err := store.Thunderbird.Get(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expected the retry to succeed")
+		assert.Equal(t, "Thunderbird", r.Name, "Expected the row")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+}
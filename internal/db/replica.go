@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type readYourWritesCtxKey struct{}
+
+// replica is a read replica of the primary with its own prepared statements.
+// Reads that run outside of a tx go to it while it is healthy.
+type replica struct {
+	db    *sql.DB
+	stmts *stmtRegistry
+
+	// down is set when a read fails to reach the replica, and cleared by the next
+	// successful Store.Ping
+	down atomic.Bool
+}
+
+// ReadYourWrites returns a ctx whose reads go to the primary rather than the read
+// replica, so they see writes the caller has just made before they replicate
+func ReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesCtxKey{}, true)
+}
+
+// readsPrimary reports whether ctx was returned by ReadYourWrites
+func readsPrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(readYourWritesCtxKey{}).(bool)
+	return v
+}
+
+// use reports whether a read with ctx should go to the replica
+func (r *replica) use(ctx context.Context) bool {
+	return r != nil && !r.down.Load() && !readsPrimary(ctx)
+}
+
+// observe marks the replica down when a read from it failed for any reason other
+// than an error from the server or no rows, e.g. a *net.OpError from a replica that
+// is down, so the reads after it go to the primary. It reports whether it did, in
+// which case the read is run again on the primary.
+func (r *replica) observe(err error) bool {
+	if !replicaFailed(err) {
+		return false
+	}
+	r.down.Store(true)
+	return true
+}
+
+// replicaFailed reports whether err means the replica could not serve a read
+func replicaFailed(err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var mysqlErr *mysql.MySQLError
+	return !errors.As(err, &mysqlErr)
+}
+
+// onPrimary is the observe func of a read from the primary, which is never run
+// again elsewhere
+func onPrimary(error) bool {
+	return false
+}
+
+// ping checks the replica, routing reads back to it once it answers
+func (r *replica) ping(ctx context.Context) error {
+	if err := r.db.PingContext(ctx); err != nil {
+		r.down.Store(true)
+		return err
+	}
+	r.down.Store(false)
+	return nil
+}
+
+// readStmt returns the prepared statement name for a read outside of a tx, on the
+// replica when it should be used, and a func to call with the read's error that
+// reports whether to run the read again on the primary. The primary's statement is
+// returned if the replica's could not be prepared.
+func (svc *thunderbirdService) readStmt(ctx context.Context, name stmtName) (*sql.Stmt, func(err error) bool, error) {
+	if svc.replica.use(ctx) {
+		stmt, err := svc.replica.stmts.get(name)
+		if err == nil {
+			return stmt, svc.replica.observe, nil
+		}
+		svc.replica.observe(err)
+	}
+
+	stmt, err := svc.stmts.get(name)
+	return stmt, onPrimary, err
+}
+
+// readDB returns the db for a dynamic read outside of a tx, the replica when it
+// should be used, and a func to call with the read's error like readStmt's
+func (svc *thunderbirdService) readDB(ctx context.Context) (*sql.DB, func(err error) bool) {
+	if svc.replica.use(ctx) {
+		return svc.replica.db, svc.replica.observe
+	}
+	return svc.db, onPrimary
+}
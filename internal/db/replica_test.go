@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Replica(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctx := context.Background()
+	newStore := func(t *testing.T) (*Store, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+		store, mock, readerMock, err := NewTestDBWithReplica(map[string]string{
+			"get-thunderbird": "SELECT thunderbirds",
+		})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		store.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+		return store, mock, readerMock
+	}
+	row := func(name string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}).
+			AddRow(thunderbirdID, name, 1, createdAt, nil)
+	}
+	expectationsMet := func(t *testing.T, mock, readerMock sqlmock.Sqlmock) {
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all primary mock conditions to be met")
+		assert.NoError(t, readerMock.ExpectationsWereMet(), "Expecting all replica mock conditions to be met")
+	}
+
+	// ensures reads outside of a tx go to the replica
+	t.Run("Read", func(t *testing.T) {
+		store, mock, readerMock := newStore(t)
+		readerMock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(row("Replica"))
+
+		r, err := store.Thunderbird.Get(ctx, thunderbirdID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Replica", r.Name, "Expected the replica's row")
+		expectationsMet(t, mock, readerMock)
+	})
+
+	// ensures reads that must see the caller's writes, or run in a tx, go to the primary
+	t.Run("Primary", func(t *testing.T) {
+		store, mock, readerMock := newStore(t)
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(row("Primary"))
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(row("Primary"))
+		mock.ExpectCommit()
+
+		r, err := store.Thunderbird.Get(ReadYourWrites(ctx), thunderbirdID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Primary", r.Name, "Expected the primary's row")
+
+		err = store.WithTx(ctx, nil, func(ctx context.Context) error {
+			r, err = store.Thunderbird.GetTx(ctx, thunderbirdID)
+			return err
+		})
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Primary", r.Name, "Expected the primary's row")
+		expectationsMet(t, mock, readerMock)
+	})
+
+	// ensures reads fall back to the primary while the replica is unreachable, until a ping succeeds
+	t.Run("Fallback", func(t *testing.T) {
+		store, mock, readerMock := newStore(t)
+		readerMock.ExpectQuery("SELECT thunderbirds").WillReturnError(mysql.ErrInvalidConn)
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(row("Primary"))
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(row("Primary"))
+		readerMock.ExpectPing()
+		readerMock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(row("Replica"))
+
+		r, err := store.Thunderbird.Get(ctx, thunderbirdID)
+		assert.NoError(t, err, "Expected the retry to fall back to the primary")
+		assert.Equal(t, "Primary", r.Name, "Expected the primary's row")
+
+		r, err = store.Thunderbird.Get(ctx, thunderbirdID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Primary", r.Name, "Expected reads to stay on the primary")
+
+		assert.NoError(t, store.Ping(ctx), "Expected no error")
+		r, err = store.Thunderbird.Get(ctx, thunderbirdID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Replica", r.Name, "Expected reads to return to the replica")
+		expectationsMet(t, mock, readerMock)
+	})
+	// ensures any error that is not from the server, like a refused connection, sends
+	// the read to the primary, and that server errors and missing rows do not
+	t.Run("Unreachable", func(t *testing.T) {
+		store, mock, readerMock := newStore(t)
+		refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}
+		readerMock.ExpectQuery("SELECT thunderbirds").WillReturnError(&mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"})
+		readerMock.ExpectQuery("SELECT thunderbirds").WillReturnRows(sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}))
+		readerMock.ExpectQuery("SELECT thunderbirds").WillReturnError(refused)
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnRows(row("Primary"))
+
+		_, err := store.Thunderbird.Get(ctx, thunderbirdID)
+		assert.Error(t, err, "Expected the server error")
+		_, err = store.Thunderbird.Get(ctx, thunderbirdID)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected not found from the replica")
+		assert.True(t, store.replica.use(ctx), "Expected the replica to stay up")
+
+		r, err := store.Thunderbird.Get(ctx, thunderbirdID)
+		assert.NoError(t, err, "Expected the read to be run again on the primary")
+		assert.Equal(t, "Primary", r.Name, "Expected the primary's row")
+		assert.False(t, store.replica.use(ctx), "Expected the replica to be marked down")
+		expectationsMet(t, mock, readerMock)
+	})
+}
+
+func TestNewReplica(t *testing.T) {
+	// ensures an unreachable replica starts marked down rather than failing the store
+	t.Run("Unreachable", func(t *testing.T) {
+		reader, readerMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		readerMock.ExpectPing().WillReturnError(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")})
+
+		r, failures, err := newReplica(reader)
+		assert.Error(t, err, "Expected the ping error")
+		assert.Empty(t, failures, "Expected no statement to be prepared")
+		assert.False(t, r.use(context.Background()), "Expected the replica to start down")
+		assert.NoError(t, readerMock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures statements that fail to prepare on the replica are reported but not required
+	t.Run("Prepare", func(t *testing.T) {
+		reader, readerMock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		noTable := &mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"}
+		readerMock.ExpectPing()
+		for _, name := range stmtNames(statements) {
+			prepare := readerMock.ExpectPrepare(regexp.QuoteMeta(statements[name]))
+			if name == getThunderbirdStmt {
+				prepare.WillReturnError(noTable)
+			}
+		}
+
+		r, failures, err := newReplica(reader)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, []StmtError{{Name: "get-thunderbird", Err: noTable, Replica: true}}, failures, "Expected the failed statement")
+		assert.True(t, r.use(context.Background()), "Expected the replica to be up")
+		assert.NoError(t, readerMock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+}
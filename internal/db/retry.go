@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers that roll back the statement or tx they occur in and are
+// likely to succeed when run again
+const (
+	mysqlErrLockWaitTimeout = 1205
+	mysqlErrDeadlock        = 1213
+	// the statement handle is unknown to the server or must be prepared again
+	mysqlErrUnknownStmtHandler = 1243
+	mysqlErrNeedReprepare      = 1615
+)
+
+// withTxRetryName is the name reported to a RetryHook when WithTx re-runs its fn
+const withTxRetryName = "with-tx"
+
+// RetryPolicy bounds how operations that fail with a transient error are retried.
+// The delay before each retry doubles from BaseDelay up to MaxDelay, with jitter,
+// and no retry is made that could not start before the ctx deadline.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times an operation is run, 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay before any retry
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is the policy a Store starts with
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   25 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+// RetryHook is called before an operation is retried, with the name of the
+// statement or mutation, the attempt about to be made (2 for the first retry) and
+// the error that caused the retry
+type RetryHook func(name string, attempt int, err error)
+
+// retrier runs operations under the Store's RetryPolicy, shared with each of its
+// services
+type retrier struct {
+	policy RetryPolicy
+	hooks  []RetryHook
+}
+
+// newRetrier creates a retrier with DefaultRetryPolicy
+func newRetrier() *retrier {
+	return &retrier{policy: DefaultRetryPolicy}
+}
+
+// SetRetryPolicy replaces the policy transient errors are retried under. It must
+// be set before the store is used.
+func (s *Store) SetRetryPolicy(p RetryPolicy) {
+	s.retry.policy = p
+}
+
+// AddRetryHook registers h to be called before every retry the store makes.
+// Hooks must be added before the store is used.
+func (s *Store) AddRetryHook(h RetryHook) {
+	s.retry.hooks = append(s.retry.hooks, h)
+}
+
+// commitError marks an error from committing a tx. A dropped connection during a
+// commit leaves it unknown whether the tx was applied, so only errors the server
+// reports as rolling the tx back are retried.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+// IsTransient reports whether err is a deadlock, lock wait timeout, dropped
+// connection or stale prepared statement, after which running the whole statement
+// or tx again may succeed
+func IsTransient(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlErrDeadlock, mysqlErrLockWaitTimeout, mysqlErrUnknownStmtHandler, mysqlErrNeedReprepare:
+			return true
+		}
+		return false
+	}
+
+	var commitErr *commitError
+	if errors.As(err, &commitErr) {
+		return false
+	}
+	return isConnError(err)
+}
+
+// isStaleStmt reports whether err is from running a prepared statement the server
+// no longer has prepared
+func isStaleStmt(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	return mysqlErr.Number == mysqlErrUnknownStmtHandler || mysqlErr.Number == mysqlErrNeedReprepare
+}
+
+// isConnError reports whether err is from a connection that dropped or could not
+// be used
+func isConnError(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// do runs fn until it succeeds, returns an error that is not transient, or the
+// policy's attempts or the ctx deadline run out. fn must be safe to run again
+// after a failure, i.e. it must run whole statements or txs of its own.
+func (r *retrier) do(ctx context.Context, name string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsTransient(err) || r == nil || attempt >= r.policy.MaxAttempts {
+			return err
+		}
+
+		delay := r.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		for _, h := range r.hooks {
+			h(name, attempt+1, err)
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// delay returns the jittered backoff before the retry following attempt, between
+// half and all of BaseDelay doubled for each previous retry, capped at MaxDelay
+func (r *retrier) delay(attempt int) time.Duration {
+	d := r.policy.BaseDelay
+	for i := 1; i < attempt && d < r.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > r.policy.MaxDelay {
+		d = r.policy.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsTransient(t *testing.T) {
+	// ensures deadlocks, lock wait timeouts, stale statements and dropped connections
+	// are retried, unless the connection dropped during a commit
+	t.Run("Classification", func(t *testing.T) {
+		deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+		assert.True(t, IsTransient(deadlock), "Expected a deadlock to be transient")
+		assert.True(t, IsTransient(fmt.Errorf("wrapped: %w", deadlock)), "Expected a wrapped deadlock to be transient")
+		assert.True(t, IsTransient(&mysql.MySQLError{Number: 1205}), "Expected a lock wait timeout to be transient")
+		assert.True(t, IsTransient(driver.ErrBadConn), "Expected a bad connection to be transient")
+		assert.True(t, IsTransient(mysql.ErrInvalidConn), "Expected an invalid connection to be transient")
+		assert.True(t, IsTransient(&commitError{err: deadlock}), "Expected a deadlock on commit to be transient")
+		assert.True(t, IsTransient(&mysql.MySQLError{Number: 1243}), "Expected an unknown statement handler to be transient")
+		assert.True(t, IsTransient(&mysql.MySQLError{Number: 1615}), "Expected a statement to reprepare to be transient")
+
+		assert.False(t, IsTransient(&commitError{err: mysql.ErrInvalidConn}), "Expected a dropped commit not to be transient")
+		assert.False(t, IsTransient(&mysql.MySQLError{Number: 1062}), "Expected a duplicate key not to be transient")
+		assert.False(t, IsTransient(ErrNotFound), "Expected not found not to be transient")
+		assert.False(t, IsTransient(nil), "Expected no error not to be transient")
+	})
+}
+
+func TestStore_Retry(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}
+	newStore := func(t *testing.T) (*Store, sqlmock.Sqlmock, *[]string) {
+		store, mock, err := NewTestDB(map[string]string{
+			"delete-thunderbird":  "UPDATE thunderbirds",
+			"create-outbox-event": "INSERT outbox",
+		})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		store.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+		retries := &[]string{}
+		store.AddRetryHook(func(name string, attempt int, err error) {
+			*retries = append(*retries, fmt.Sprintf("%s %d", name, attempt))
+		})
+		return store, mock, retries
+	}
+
+	// ensures a deadlocked mutation runs again in a new tx
+	t.Run("Mutation", func(t *testing.T) {
+		store, mock, retries := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").WillReturnError(deadlock)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err := store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expected the retry to succeed")
+		assert.Equal(t, []string{"delete-thunderbird 2"}, *retries, "Expected one retry to be reported")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures errors that are not transient are returned straight away
+	t.Run("Not transient", func(t *testing.T) {
+		store, mock, retries := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected not found")
+		assert.Empty(t, *retries, "Expected no retry")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures a connection dropped during commit is not retried, as the commit may have applied
+	t.Run("Dropped commit", func(t *testing.T) {
+		store, mock, retries := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit().WillReturnError(mysql.ErrInvalidConn)
+
+		err := store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.ErrorIs(t, err, mysql.ErrInvalidConn, "Expected the commit error")
+		assert.Empty(t, *retries, "Expected no retry")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures retries stop once the attempts run out
+	t.Run("Max attempts", func(t *testing.T) {
+		store, mock, retries := newStore(t)
+		for i := 0; i < 3; i++ {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE thunderbirds").WillReturnError(deadlock)
+			mock.ExpectRollback()
+		}
+
+		err := store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.ErrorIs(t, err, deadlock, "Expected the last deadlock")
+		assert.Equal(t, []string{"delete-thunderbird 2", "delete-thunderbird 3"}, *retries, "Expected two retries")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures no retry is made that could not start before the deadline
+	t.Run("Deadline", func(t *testing.T) {
+		store, mock, retries := newStore(t)
+		store.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").WillReturnError(deadlock)
+		mock.ExpectRollback()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		err := store.Thunderbird.Delete(ctx, thunderbirdID)
+		assert.ErrorIs(t, err, deadlock, "Expected the deadlock")
+		assert.Empty(t, *retries, "Expected no retry")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures WithTx runs the whole closure again, while its Tx methods do not retry alone
+	t.Run("WithTx", func(t *testing.T) {
+		store, mock, retries := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").WillReturnError(deadlock)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		runs := 0
+		err := store.WithTx(context.Background(), nil, func(ctx context.Context) error {
+			runs++
+			return store.Thunderbird.DeleteTx(ctx, thunderbirdID)
+		})
+		assert.NoError(t, err, "Expected the retry to succeed")
+		assert.Equal(t, 2, runs, "Expected the closure to run twice")
+		assert.Equal(t, []string{"with-tx 2"}, *retries, "Expected one retry of the tx")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+}
+
+func TestRetrier_delay(t *testing.T) {
+	// ensures the backoff doubles with jitter and is capped
+	t.Run("Backoff", func(t *testing.T) {
+		r := &retrier{policy: RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}}
+		for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 6: time.Second} {
+			d := r.delay(attempt)
+			assert.True(t, d >= max/2 && d <= max, fmt.Sprintf("Expected attempt %d to wait between %s and %s, got %s", attempt, max/2, max, d))
+		}
+	})
+
+	// ensures a store without a retrier runs each operation once
+	t.Run("No retrier", func(t *testing.T) {
+		var r *retrier
+		calls := 0
+		err := r.do(context.Background(), "get-thunderbird", func() error {
+			calls++
+			return driver.ErrBadConn
+		})
+		assert.ErrorIs(t, err, driver.ErrBadConn, "Expected the error")
+		assert.Equal(t, 1, calls, "Expected a nil retrier to run once")
+	})
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/go-sql-driver/mysql"
+)
+
+// EnsureSchema creates the schema named in dataSourceName if it does not exist
+// yet. Migrations never name a schema, they run against whichever one the
+// connection selects, so this lets one binary migrate dev, stg, prod or a
+// throwaway test schema.
+func EnsureSchema(dataSourceName string) error {
+	cfg, err := mysql.ParseDSN(dataSourceName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	name := cfg.DBName
+	if name == "" {
+		return errors.New("the connection does not select a schema")
+	}
+
+	// connect to the server without selecting the schema that may not exist
+	cfg.DBName = ""
+	conn, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	return ensureSchema(conn, name)
+}
+
+// ensureSchema creates the named schema on conn unless it already exists. The
+// lookup comes first so a user without the CREATE privilege can still start
+// against a schema provisioned for it.
+func ensureSchema(conn *sql.DB, name string) error {
+	errMsg := func() string { return "Error ensuring schema - " + name }
+
+	var exists int
+	err := conn.QueryRow(
+		"SELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", name,
+	).Scan(&exists)
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+	if exists > 0 {
+		return nil
+	}
+
+	// the default COLLATE for utf8mb4 is utf8mb4_0900_ai_ci
+	_, err = conn.Exec("CREATE SCHEMA IF NOT EXISTS " + quoteIdent(name) + " CHARACTER SET utf8mb4 COLLATE utf8mb4_0900_ai_ci")
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+	return nil
+}
+
+// quoteIdent quotes name as a MySQL identifier, doubling any backtick within it,
+// so names such as ford-thunderbird that are not valid unquoted can be used
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnsureSchema(t *testing.T) {
+	// ensures an existing schema is left alone
+	t.Run("Existing schema", func(t *testing.T) {
+		conn, mock, err := sqlmock.New()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT COUNT").
+			WithArgs("thunderbirds_dev").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+		err = ensureSchema(conn, "thunderbirds_dev")
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a missing schema is created
+	t.Run("Missing schema", func(t *testing.T) {
+		conn, mock, err := sqlmock.New()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT COUNT").
+			WithArgs("thunderbirds_test_1").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec("CREATE SCHEMA IF NOT EXISTS `thunderbirds_test_1`").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = ensureSchema(conn, "thunderbirds_test_1")
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a name that is not a valid unquoted identifier is quoted
+	t.Run("Hyphenated name", func(t *testing.T) {
+		conn, mock, err := sqlmock.New()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT COUNT").
+			WithArgs("ford-thunderbird").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("CREATE SCHEMA IF NOT EXISTS `ford-thunderbird`")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = ensureSchema(conn, "ford-thunderbird")
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a backtick within a name is escaped rather than ending the identifier
+	t.Run("Backtick in name", func(t *testing.T) {
+		conn, mock, err := sqlmock.New()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT COUNT").
+			WithArgs("thunderbirds`; DROP TABLE thunderbirds").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec(regexp.QuoteMeta("CREATE SCHEMA IF NOT EXISTS `thunderbirds``; DROP TABLE thunderbirds`")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = ensureSchema(conn, "thunderbirds`; DROP TABLE thunderbirds")
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
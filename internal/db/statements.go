@@ -1,13 +1,99 @@
 package db
 
-var statements = map[string]string{
-  // inserts a new row into the thunderbirds table
-  "create-thunderbird": `
+// stmtName names a statement run by the store. It is an enum rather than a
+// string so a misspelt name fails to compile rather than finding no statement.
+type stmtName int
+
+// Names of the prepared statements in statements
+const (
+	createThunderbirdStmt stmtName = iota
+	deleteThunderbirdStmt
+	restoreThunderbirdStmt
+	listPurgeableThunderbirdsStmt
+	purgeThunderbirdStmt
+	getThunderbirdStmt
+	getDeletedThunderbirdStmt
+	lockThunderbirdStmt
+	listThunderbirdsByNameStmt
+	listThunderbirdsByNameDescStmt
+	listThunderbirdsByCreatedAtStmt
+	listThunderbirdsByCreatedAtDescStmt
+	claimIdempotencyKeyStmt
+	getIdempotencyKeyStmt
+	purgeIdempotencyKeysStmt
+	createOutboxEventStmt
+	listOutboxEventsStmt
+	claimOutboxEventStmt
+	releaseOutboxEventStmt
+	publishOutboxEventStmt
+	purgeOutboxEventsStmt
+	getLastChangeIDStmt
+	listChangesStmt
+
+	// names reported to a QueryHook for the dynamic queries that are not prepared
+	loadThunderbirdsQueryName
+	updateThunderbirdQueryName
+)
+
+// stmtNameText is the name of each statement as reported in errors, hooks and metrics
+var stmtNameText = [...]string{
+	createThunderbirdStmt:               "create-thunderbird",
+	deleteThunderbirdStmt:               "delete-thunderbird",
+	restoreThunderbirdStmt:              "restore-thunderbird",
+	listPurgeableThunderbirdsStmt:       "list-purgeable-thunderbirds",
+	purgeThunderbirdStmt:                "purge-thunderbird",
+	getThunderbirdStmt:                  "get-thunderbird",
+	getDeletedThunderbirdStmt:           "get-deleted-thunderbird",
+	lockThunderbirdStmt:                 "lock-thunderbird",
+	listThunderbirdsByNameStmt:          "list-thunderbirds-by-name",
+	listThunderbirdsByNameDescStmt:      "list-thunderbirds-by-name-desc",
+	listThunderbirdsByCreatedAtStmt:     "list-thunderbirds-by-created-at",
+	listThunderbirdsByCreatedAtDescStmt: "list-thunderbirds-by-created-at-desc",
+	claimIdempotencyKeyStmt:             "claim-idempotency-key",
+	getIdempotencyKeyStmt:               "get-idempotency-key",
+	purgeIdempotencyKeysStmt:            "purge-idempotency-keys",
+	createOutboxEventStmt:               "create-outbox-event",
+	listOutboxEventsStmt:                "list-outbox-events",
+	claimOutboxEventStmt:                "claim-outbox-event",
+	releaseOutboxEventStmt:              "release-outbox-event",
+	publishOutboxEventStmt:              "publish-outbox-event",
+	purgeOutboxEventsStmt:               "purge-outbox-events",
+	getLastChangeIDStmt:                 "get-last-change-id",
+	listChangesStmt:                     "list-changes",
+	loadThunderbirdsQueryName:           "load-thunderbirds",
+	updateThunderbirdQueryName:          "update-thunderbird",
+}
+
+func (n stmtName) String() string {
+	return stmtNameText[n]
+}
+
+// optionalStatements back the purge and outbox jobs and idempotent creates. The
+// store can start while they fail to prepare, e.g. before the migration adding
+// their table has run, and they are prepared when next used.
+var optionalStatements = map[stmtName]bool{
+	listPurgeableThunderbirdsStmt: true,
+	purgeThunderbirdStmt:          true,
+	claimIdempotencyKeyStmt:       true,
+	getIdempotencyKeyStmt:         true,
+	purgeIdempotencyKeysStmt:      true,
+	listOutboxEventsStmt:          true,
+	claimOutboxEventStmt:          true,
+	releaseOutboxEventStmt:        true,
+	publishOutboxEventStmt:        true,
+	purgeOutboxEventsStmt:         true,
+	getLastChangeIDStmt:           true,
+	listChangesStmt:               true,
+}
+
+var statements = map[stmtName]string{
+	// inserts a new row into the thunderbirds table
+	createThunderbirdStmt: `
   INSERT INTO thunderbirds (thunderbird_id, name)
     values(UUID_TO_BIN(?), ?)
   `,
-  // soft deletes a thunderbird by id
-  "delete-thunderbird": `
+	// soft deletes a thunderbird by id
+	deleteThunderbirdStmt: `
   UPDATE
     thunderbirds
   SET
@@ -16,24 +102,260 @@ var statements = map[string]string{
     thunderbird_id = UUID_TO_BIN(?)
     AND deleted_at IS NULL
   `,
-  // gets a single thunderbird row by id
-  "get-thunderbird": `
+	// restores a soft deleted thunderbird by id
+	restoreThunderbirdStmt: `
+  UPDATE
+    thunderbirds
+  SET
+    deleted_at = NULL
+  WHERE
+    thunderbird_id = UUID_TO_BIN(?)
+    AND deleted_at IS NOT NULL
+  `,
+	// locks the ids of thunderbirds soft deleted before a cutoff, oldest first
+	listPurgeableThunderbirdsStmt: `
+  SELECT
+    thunderbird_id
+  FROM
+    thunderbirds
+  WHERE
+    deleted_at < ?
+  ORDER BY
+    deleted_at
+  LIMIT ?
+  FOR UPDATE
+  `,
+	// hard deletes a soft deleted thunderbird by id
+	purgeThunderbirdStmt: `
+  DELETE FROM
+    thunderbirds
+  WHERE
+    thunderbird_id = UUID_TO_BIN(?)
+    AND deleted_at IS NOT NULL
+  `,
+	// gets a single thunderbird row by id
+	getThunderbirdStmt: `
   SELECT
-    thunderbird_id, name
+    thunderbird_id, name, version, created_at, deleted_at
   FROM
     thunderbirds
   WHERE
     thunderbird_id = UUID_TO_BIN(?)
     AND deleted_at IS NULL
   `,
-  // update a single thunderbird row by ID
-  "update-thunderbird": `
-  UPDATE
+	// gets a single soft deleted thunderbird row by id
+	getDeletedThunderbirdStmt: `
+  SELECT
+    thunderbird_id, name, version, created_at, deleted_at
+  FROM
+    thunderbirds
+  WHERE
+    thunderbird_id = UUID_TO_BIN(?)
+    AND deleted_at IS NOT NULL
+  `,
+	// locks a single thunderbird row by ID and returns its version
+	lockThunderbirdStmt: `
+  SELECT
+    version
+  FROM
     thunderbirds
-  SET
-    name = ?
   WHERE
     thunderbird_id = UUID_TO_BIN(?)
     AND deleted_at IS NULL
+  FOR UPDATE
+  `,
+	// lists a page of thunderbirds ordered by name, starting after the
+	// (name, thunderbird_id) cursor unless the first placeholder is true
+	listThunderbirdsByNameStmt: `
+  SELECT
+    thunderbird_id, name, version, created_at, deleted_at
+  FROM
+    thunderbirds
+  WHERE
+    (? OR deleted_at IS NULL)
+    AND name LIKE CONCAT(?, '%')
+    AND (? OR (name, thunderbird_id) > (?, UUID_TO_BIN(?)))
+  ORDER BY
+    name, thunderbird_id
+  LIMIT ?
+  `,
+	// lists a page of thunderbirds ordered by name descending
+	listThunderbirdsByNameDescStmt: `
+  SELECT
+    thunderbird_id, name, version, created_at, deleted_at
+  FROM
+    thunderbirds
+  WHERE
+    (? OR deleted_at IS NULL)
+    AND name LIKE CONCAT(?, '%')
+    AND (? OR (name, thunderbird_id) < (?, UUID_TO_BIN(?)))
+  ORDER BY
+    name DESC, thunderbird_id DESC
+  LIMIT ?
+  `,
+	// lists a page of thunderbirds ordered by creation time, starting after the
+	// (created_at, thunderbird_id) cursor unless the first placeholder is true
+	listThunderbirdsByCreatedAtStmt: `
+  SELECT
+    thunderbird_id, name, version, created_at, deleted_at
+  FROM
+    thunderbirds
+  WHERE
+    (? OR deleted_at IS NULL)
+    AND name LIKE CONCAT(?, '%')
+    AND (? OR (created_at, thunderbird_id) > (?, UUID_TO_BIN(?)))
+  ORDER BY
+    created_at, thunderbird_id
+  LIMIT ?
+  `,
+	// lists a page of thunderbirds ordered by creation time descending
+	listThunderbirdsByCreatedAtDescStmt: `
+  SELECT
+    thunderbird_id, name, version, created_at, deleted_at
+  FROM
+    thunderbirds
+  WHERE
+    (? OR deleted_at IS NULL)
+    AND name LIKE CONCAT(?, '%')
+    AND (? OR (created_at, thunderbird_id) < (?, UUID_TO_BIN(?)))
+  ORDER BY
+    created_at DESC, thunderbird_id DESC
+  LIMIT ?
+  `,
+	// claims an idempotency key for a thunderbird create. inserts 1 row for a new key,
+	// takes over an expired key as 2 rows, and leaves a live key untouched as 0 rows.
+	// expires_at is assigned last as each assignment sees the columns set before it.
+	claimIdempotencyKeyStmt: `
+  INSERT INTO idempotency_keys (idempotency_key, thunderbird_id, request_hash, expires_at)
+    values(?, UUID_TO_BIN(?), ?, DATE_ADD(NOW(), INTERVAL ? SECOND)) AS new
+  ON DUPLICATE KEY UPDATE
+    thunderbird_id = IF(idempotency_keys.expires_at <= NOW(), new.thunderbird_id, idempotency_keys.thunderbird_id),
+    request_hash = IF(idempotency_keys.expires_at <= NOW(), new.request_hash, idempotency_keys.request_hash),
+    expires_at = IF(idempotency_keys.expires_at <= NOW(), new.expires_at, idempotency_keys.expires_at)
+  `,
+	// gets the thunderbird created under an idempotency key, whether or not it is
+	// soft deleted, and the hash of the request that created it. the thunderbird
+	// columns are null once it is purged.
+	getIdempotencyKeyStmt: `
+  SELECT
+    k.thunderbird_id, t.name, t.version, t.created_at, t.deleted_at,
+    k.request_hash
+  FROM
+    idempotency_keys k
+    LEFT JOIN thunderbirds t ON t.thunderbird_id = k.thunderbird_id
+  WHERE
+    k.idempotency_key = ?
+  `,
+	// removes expired idempotency keys
+	purgeIdempotencyKeysStmt: `
+  DELETE FROM
+    idempotency_keys
+  WHERE
+    expires_at <= NOW()
+  LIMIT ?
+  `,
+	// records a mutation event in the outbox
+	createOutboxEventStmt: `
+  INSERT INTO outbox (aggregate_type, aggregate_id, event_type, payload)
+    values(?, UUID_TO_BIN(?), ?, ?)
+  `,
+	// locks the oldest unpublished and unclaimed outbox events, skipping any another
+	// relay holds
+	listOutboxEventsStmt: `
+  SELECT
+    event_id, aggregate_type, aggregate_id, event_type, payload, created_at
+  FROM
+    outbox
+  WHERE
+    published_at IS NULL
+    AND (claimed_until IS NULL OR claimed_until <= NOW())
+  ORDER BY
+    event_id
+  LIMIT ?
+  FOR UPDATE SKIP LOCKED
+  `,
+	// claims an outbox event for a relay for a number of seconds
+	claimOutboxEventStmt: `
+  UPDATE
+    outbox
+  SET
+    claimed_until = DATE_ADD(NOW(), INTERVAL ? SECOND)
+  WHERE
+    event_id = ?
+  `,
+	// releases the claim on an outbox event that failed to publish
+	releaseOutboxEventStmt: `
+  UPDATE
+    outbox
+  SET
+    claimed_until = NULL
+  WHERE
+    event_id = ?
+  `,
+	// marks an outbox event published, it is kept for the change feed until purged
+	publishOutboxEventStmt: `
+  UPDATE
+    outbox
+  SET
+    published_at = NOW(), claimed_until = NULL
+  WHERE
+    event_id = ?
+  `,
+	// removes outbox events published more than a number of seconds ago. Events are
+	// not deleted when they are published, as the 010002 migration describes, but
+	// marked and kept for the change feed until this purges them.
+	purgeOutboxEventsStmt: `
+  DELETE FROM
+    outbox
+  WHERE
+    published_at <= DATE_SUB(NOW(), INTERVAL ? SECOND)
+  LIMIT ?
+  `,
+	// gets the event_id of the newest outbox event, 0 when there is none
+	getLastChangeIDStmt: `
+  SELECT
+    COALESCE(MAX(event_id), 0)
+  FROM
+    outbox
+  `,
+	// lists the outbox events after an event_id for the change feed
+	listChangesStmt: `
+  SELECT
+    event_id, aggregate_id, event_type, payload, created_at
+  FROM
+    outbox
+  WHERE
+    event_id > ?
+  ORDER BY
+    event_id
+  LIMIT ?
   `,
 }
+
+// loadThunderbirdsQuery gets every thunderbird in a set of ids. The size of the
+// IN list varies per call so it is not prepared up front, %s is replaced with
+// one UUID_TO_BIN(?) placeholder per id.
+const loadThunderbirdsQuery = `
+  SELECT
+    thunderbird_id, name, version, created_at, deleted_at
+  FROM
+    thunderbirds
+  WHERE
+    thunderbird_id IN (%s)
+    AND deleted_at IS NULL
+  `
+
+// updateThunderbirdQuery updates a single thunderbird row by ID if it is still at
+// the given version. The columns written vary per call so it is not prepared up
+// front, %s is replaced with one "column = ?" assignment per updated field.
+const updateThunderbirdQuery = `
+  UPDATE
+    thunderbirds
+  SET
+    %s,
+    version = version + 1
+  WHERE
+    thunderbird_id = UUID_TO_BIN(?)
+    AND version = ?
+    AND deleted_at IS NULL
+  `
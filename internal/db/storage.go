@@ -0,0 +1,45 @@
+package db
+
+import "context"
+
+// Storage is the backing store the service runs on. NewStorage implements it on
+// MySQL and MemoryStore implements it in process for tests and local development.
+// Transactions are run with WithTx, the BeginTx, CommitTx and RollbackTx helpers
+// of Store and MemoryStore are left to callers holding the concrete store.
+type Storage interface {
+	// Thunderbirds returns the API for reading and writing thunderbirds
+	Thunderbirds() ThunderbirdStore
+	// Subscribe returns a subscription to the committed thunderbird mutations made
+	// after the one that issued token, or after now when token is empty
+	Subscribe(ctx context.Context, token string) (*Subscription, error)
+	// WithTx runs fn within a transaction, committing it if fn succeeds and rolling
+	// it back otherwise. Nested calls only roll back their own writes.
+	WithTx(ctx context.Context, opts *TxOptions, fn func(ctx context.Context) error) error
+	// Ping checks that the store is available
+	Ping(ctx context.Context) error
+	// Close releases the store's resources
+	Close() error
+}
+
+var (
+	_ Storage = storeStorage{}
+	_ Storage = &MemoryStore{}
+)
+
+// storeStorage adapts a Store, which exposes its services as fields, to Storage
+type storeStorage struct {
+	*Store
+}
+
+// NewStorage returns the Storage backed by s
+func NewStorage(s *Store) Storage {
+	return storeStorage{s}
+}
+
+func (s storeStorage) Thunderbirds() ThunderbirdStore {
+	return s.Thunderbird
+}
+
+func (s storeStorage) Subscribe(ctx context.Context, token string) (*Subscription, error) {
+	return s.Changes.Subscribe(ctx, token)
+}
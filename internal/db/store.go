@@ -3,9 +3,12 @@ package db
 import (
 	"context"
 	"database/sql"
+	"sync"
+	"time"
 
 	"github.com/caring/go-packages/pkg/errors"
 	_ "github.com/caring/go-packages/pkg/uuid"
+	"github.com/google/uuid"
 	// anonymous import so package exports are not exposed
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -14,58 +17,135 @@ type ctxKey struct{}
 
 var txCtxKey = ctxKey{}
 
+// changePollInterval is how often a subscription to the Store's feed polls the
+// outbox for new changes
+const changePollInterval = 500 * time.Millisecond
+
+// txState is stored within a context by ToCtx. It counts the savepoints opened by
+// nested WithTx calls to name them uniquely, keeping the error of the first that
+// failed to roll back.
+type txState struct {
+	tx *sql.Tx
+
+	mu           sync.Mutex
+	savepoints   int
+	savepointErr error
+}
+
 // Store represents a connection and a collection
 // of statements that we will use to interface with
-// a backing store
+// a backing store. Each table is exposed through its
+// own service interface.
 type Store struct {
 	db    *sql.DB
-	stmts map[string]*sql.Stmt
+	stmts *stmtRegistry
+	hooks *queryHooks
+	retry *retrier
+	// replica serves reads made outside of a tx, nil without a reader
+	replica *replica
+
+	Thunderbird ThunderbirdStore
+	// Outbox relays the events recorded by mutations
+	Outbox OutboxStore
+	// Changes streams every committed thunderbird mutation from the outbox, made by
+	// any process sharing the db
+	Changes *ChangeFeed
 }
 
 // NewStore will give a pointer to a MySQL instance ready to run queries against.
-func NewStore(dataSourceName string) (*Store, error) {
-	unprepared := statements
-
+// If readerDataSourceName is not empty, reads made outside of a tx are sent to
+// that read replica of the primary instead.
+//
+// Statements that fail to prepare are prepared again when next used, and reads go
+// to the primary while the replica cannot be reached, so the store is returned
+// along with a *PrepareError reporting both, and the caller decides whether it can
+// run without them. On any other error the store is nil and its connections are
+// closed.
+func NewStore(dataSourceName, readerDataSourceName string) (*Store, error) {
 	db, err := sql.Open("mysql", dataSourceName)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	stmts, err := prepareStmts(db, unprepared)
+	err = db.Ping()
 	if err != nil {
+		db.Close()
 		return nil, errors.WithStack(err)
 	}
 
-	err = db.Ping()
-	if err != nil {
-		return nil, errors.WithStack(err)
+	stmts := newStmtRegistry(db, statements)
+	failures := stmts.prepare()
+
+	var (
+		r          *replica
+		replicaErr error
+	)
+	if readerDataSourceName != "" {
+		reader, err := sql.Open("mysql", readerDataSourceName)
+		if err != nil {
+			db.Close()
+			return nil, errors.WithStack(err)
+		}
+
+		var readerFailures []StmtError
+		r, readerFailures, replicaErr = newReplica(reader)
+		failures = append(failures, readerFailures...)
+	}
+
+	s := newStore(db, stmts, r)
+	if len(failures) > 0 || replicaErr != nil {
+		return s, &PrepareError{Failures: failures, ReplicaErr: replicaErr}
 	}
+	return s, nil
+}
 
-	s := Store{
-		db:    db,
-		stmts: stmts,
+// newReplica prepares the statements on a read replica. A replica that cannot be
+// reached does not stop the store starting: it starts marked down, with the ping's
+// error returned, so reads go to the primary until Store.Ping reaches it, and its
+// statements are prepared when next used.
+func newReplica(reader *sql.DB) (*replica, []StmtError, error) {
+	r := &replica{db: reader, stmts: newStmtRegistry(reader, statements)}
+	if err := reader.Ping(); err != nil {
+		r.down.Store(true)
+		return r, nil, errors.WithStack(err)
 	}
 
-	return &s, nil
+	failures := r.stmts.prepare()
+	for i := range failures {
+		// reads fall back to the primary's statement
+		failures[i].Replica, failures[i].Required = true, false
+	}
+	return r, failures, nil
 }
 
-// prepareStmts will attempt to prepare each unprepared
-// query on the database. If one fails, the function returns
-// with an error.
-func prepareStmts(db *sql.DB, unprepared map[string]string) (map[string]*sql.Stmt, error) {
-	prepared := map[string]*sql.Stmt{}
-	for k, v := range unprepared {
-		stmt, err := db.Prepare(v)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-		prepared[k] = stmt
+// newStore builds a Store and its services around the statements prepared on db
+// and the read replica, which may be nil
+func newStore(db *sql.DB, stmts *stmtRegistry, r *replica) *Store {
+	hooks := &queryHooks{}
+	retry := newRetrier()
+	outbox := newOutboxService(db, stmts, hooks)
+
+	return &Store{
+		db:          db,
+		stmts:       stmts,
+		hooks:       hooks,
+		retry:       retry,
+		replica:     r,
+		Thunderbird: newThunderbirdService(db, stmts, hooks, retry, r),
+		Outbox:      outbox,
+		Changes:     NewChangeFeed(outbox, changePollInterval, changeSettle, changeRetention),
 	}
-	return prepared, nil
 }
 
 // Close will close the connection to the underlying database
 func (s *Store) Close() error {
+	if s.replica != nil {
+		if err := s.replica.db.Close(); err != nil {
+			s.db.Close()
+			return errors.WithStack(err)
+		}
+	}
+
 	err := s.db.Close()
 	if err != nil {
 		return errors.WithStack(err)
@@ -73,15 +153,27 @@ func (s *Store) Close() error {
 	return nil
 }
 
-// Ping will check the connection to the underlying database
+// Ping will check the connection to the underlying database. The read replica is
+// checked too, routing reads away from it while it does not answer and back once it
+// does, but only the primary's error is returned as reads fall back to it.
 func (s *Store) Ping(ctx context.Context) error {
-	if err = s.db.PingContext(ctx); err != nil {
-    return err
-  }
-  return nil
+	if s.replica != nil {
+		s.replica.ping(ctx)
+	}
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
 }
 
-// GetTx initializes a db transaction
+// Stats returns the connection pool statistics of the underlying database
+func (s *Store) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// GetTx initializes a db transaction. WithTx also takes a ctx and tx options, and
+// commits or rolls back for the caller.
 func (s *Store) GetTx() (*sql.Tx, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -90,17 +182,73 @@ func (s *Store) GetTx() (*sql.Tx, error) {
 	return tx, nil
 }
 
+// BeginTx starts a db transaction and returns a ctx carrying it
+func (s *Store) BeginTx(ctx context.Context) (context.Context, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return ToCtx(ctx, tx), nil
+}
+
+// RollbackTx rolls back the tx stored in ctx, discarding the changes made within it
+func (s *Store) RollbackTx(ctx context.Context) error {
+	state, err := stateFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	if err = state.tx.Rollback(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// CommitTx commits the tx stored in ctx. The changes made within it are in the
+// outbox, so they reach the feed however the tx is committed.
+func (s *Store) CommitTx(ctx context.Context) error {
+	state, err := stateFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	if err = state.tx.Commit(); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
 // ToCtx stores a sql.Tx within a context
 func ToCtx(ctx context.Context, tx *sql.Tx) context.Context {
-	return context.WithValue(ctx, txCtxKey, tx)
+	return context.WithValue(ctx, txCtxKey, &txState{tx: tx})
 }
 
 // FromCtx extracts a sql.Tx from a context which has been stored by this package,
 // returns a error if no tx is present
 func FromCtx(ctx context.Context) (*sql.Tx, error) {
+	state, err := stateFromCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return state.tx, nil
+}
+
+// stateFromCtx extracts the txState stored by ToCtx
+func stateFromCtx(ctx context.Context) (*txState, error) {
 	val := ctx.Value(txCtxKey)
 	if val != nil {
-		return val.(*sql.Tx), nil
+		return val.(*txState), nil
 	}
 	return nil, errors.New("No *sql.Tx present in context")
 }
+
+// ParseUUID parses a string into a UUID. An empty string is parsed
+// to the zero value UUID without error.
+func ParseUUID(s string) (uuid.UUID, error) {
+	if s == "" {
+		return uuid.Nil, nil
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, errors.WithStack(err)
+	}
+	return id, nil
+}
@@ -1,28 +1,138 @@
 package db
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/caring/go-packages/pkg/errors"
 	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/caring/ford-thunderbird/pb"
 )
 
-
+// ThunderbirdStore is the API for reading and writing thunderbirds. Methods
+// suffixed with Tx run inside of a transaction stored in ctx with ToCtx or
+// Store.WithTx. The Store retries the other reads and writes, each of which runs
+// whole, when they fail with a transient error.
+type ThunderbirdStore interface {
+	Get(ctx context.Context, ID uuid.UUID) (*Thunderbird, error)
+	GetTx(ctx context.Context, ID uuid.UUID) (*Thunderbird, error)
+	GetDeleted(ctx context.Context, ID uuid.UUID) (*Thunderbird, error)
+	GetDeletedTx(ctx context.Context, ID uuid.UUID) (*Thunderbird, error)
+	Create(ctx context.Context, input *Thunderbird) error
+	CreateTx(ctx context.Context, input *Thunderbird) error
+	CreateIdempotent(ctx context.Context, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error)
+	CreateIdempotentTx(ctx context.Context, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error)
+	PurgeIdempotencyKeys(ctx context.Context, limit int) (int, error)
+	Update(ctx context.Context, input *Thunderbird) error
+	UpdateTx(ctx context.Context, input *Thunderbird) error
+	UpdateFields(ctx context.Context, input *Thunderbird, fields []string) error
+	UpdateFieldsTx(ctx context.Context, input *Thunderbird, fields []string) error
+	Delete(ctx context.Context, ID uuid.UUID) error
+	DeleteTx(ctx context.Context, ID uuid.UUID) error
+	Restore(ctx context.Context, ID uuid.UUID) error
+	RestoreTx(ctx context.Context, ID uuid.UUID) error
+	Purge(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+	List(ctx context.Context, params *ListThunderbirdsParams) ([]*Thunderbird, error)
+	ListTx(ctx context.Context, params *ListThunderbirdsParams) ([]*Thunderbird, error)
+	Load(ctx context.Context, IDs []uuid.UUID) ([]*Thunderbird, error)
+	LoadTx(ctx context.Context, IDs []uuid.UUID) ([]*Thunderbird, error)
+}
 
 // thunderbirdService provides an API for interacting with the thunderbirds table
 type thunderbirdService struct {
-	db    *sql.DB
-	stmts map[string]*sql.Stmt
+	db      *sql.DB
+	stmts   *stmtRegistry
+	hooks   *queryHooks
+	retry   *retrier
+	replica *replica
+}
+
+var _ ThunderbirdStore = &thunderbirdService{}
+
+// newThunderbirdService builds a thunderbirdService from a db connection, its prepared
+// statements, the hooks run around statements, the retrier the methods that run
+// outside of a tx from ctx are retried with and the read replica those of them that
+// only read are routed to, which may be nil
+func newThunderbirdService(db *sql.DB, stmts *stmtRegistry, hooks *queryHooks, retry *retrier, replica *replica) *thunderbirdService {
+	return &thunderbirdService{
+		db:      db,
+		stmts:   stmts,
+		hooks:   hooks,
+		retry:   retry,
+		replica: replica,
+	}
+}
+
+// mutate runs fn within the tx from ctx when useTx = true, otherwise within a new tx
+// that is committed if fn succeeds. Mutations always run in a tx so the outbox event
+// is written atomically with the change it describes.
+func (svc *thunderbirdService) mutate(ctx context.Context, useTx bool, fn func(tx *sql.Tx) error) error {
+	if useTx {
+		tx, err := FromCtx(ctx)
+		if err != nil {
+			return err
+		}
+		return fn(tx)
+	}
+
+	tx, err := svc.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.WithStack(&commitError{err: err})
+	}
+	return nil
+}
+
+// thunderbirdFields maps the fields of a Thunderbird that an update may write,
+// named as in the proto, to their column. Only these columns are ever interpolated
+// into updateThunderbirdQuery.
+var thunderbirdFields = map[string]string{
+	"name": "name",
+}
+
+// ThunderbirdFields returns the names of the fields an update may write, sorted
+func ThunderbirdFields() []string {
+	fields := make([]string, 0, len(thunderbirdFields))
+	for f := range thunderbirdFields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// thunderbirdFieldValue returns the value written to a whitelisted field
+func thunderbirdFieldValue(m *Thunderbird, field string) interface{} {
+	switch field {
+	case "name":
+		return m.Name
+	}
+	return nil
 }
 
 // Thunderbird is a struct representation of a row in the thunderbirds table
 type Thunderbird struct {
-	ID  	uuid.UUID
-	Name  string
+	ID        uuid.UUID
+	Name      string
+	Version   int64
+	CreatedAt time.Time
+	DeletedAt *time.Time
 }
 
 // protoThunderbird is an interface that most proto thunderbird objects will satisfy
@@ -38,38 +148,92 @@ func NewThunderbird(ID string, proto protoThunderbird) (*Thunderbird, error) {
 	}
 
 	return &Thunderbird{
-		ID:  	mID,
+		ID:   mID,
 		Name: proto.GetName(),
 	}, nil
 }
 
 // ToProto casts a db thunderbird into a proto response object
 func (m *Thunderbird) ToProto() *pb.ThunderbirdResponse {
-	return &pb.ThunderbirdResponse{
-		Id:  				m.ID.String(),
-		Name:       m.Name,
+	r := &pb.ThunderbirdResponse{
+		Id:      m.ID.String(),
+		Name:    m.Name,
+		Version: m.Version,
+	}
+	if !m.CreatedAt.IsZero() {
+		r.CreatedAt = timestamppb.New(m.CreatedAt)
+	}
+	if m.DeletedAt != nil {
+		r.DeletedAt = timestamppb.New(*m.DeletedAt)
+	}
+	return r
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanThunderbird scans the thunderbird_id, name, version, created_at, deleted_at columns
+// shared by every thunderbird select
+func scanThunderbird(row rowScanner) (*Thunderbird, error) {
+	var (
+		p         Thunderbird
+		deletedAt sql.NullTime
+	)
+
+	if err := row.Scan(&p.ID, &p.Name, &p.Version, &p.CreatedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		p.DeletedAt = &deletedAt.Time
 	}
+
+	return &p, nil
 }
 
 // Get fetches a single thunderbird from the db
 func (svc *thunderbirdService) Get(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
-	return svc.get(ctx, false, ID)
+	var p *Thunderbird
+	err := svc.retry.do(ctx, getThunderbirdStmt.String(), func() (err error) {
+		p, err = svc.get(ctx, false, getThunderbirdStmt, ID)
+		return err
+	})
+	return p, err
 }
 
 // GetTx fetches a single thunderbird from the db inside of a tx from ctx
 func (svc *thunderbirdService) GetTx(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
-	return svc.get(ctx, true, ID)
+	return svc.get(ctx, true, getThunderbirdStmt, ID)
+}
+
+// GetDeleted fetches a single soft deleted thunderbird from the db
+func (svc *thunderbirdService) GetDeleted(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
+	var p *Thunderbird
+	err := svc.retry.do(ctx, getDeletedThunderbirdStmt.String(), func() (err error) {
+		p, err = svc.get(ctx, false, getDeletedThunderbirdStmt, ID)
+		return err
+	})
+	return p, err
 }
 
-// get fetches a single thunderbird from the db
-func (svc *thunderbirdService) get(ctx context.Context, useTx bool, ID uuid.UUID) (*Thunderbird, error) {
-	errMsg := func() string { return "Error executing get thunderbird - " + fmt.Sprint(ID) }
+// GetDeletedTx fetches a single soft deleted thunderbird from the db inside of a tx from ctx
+func (svc *thunderbirdService) GetDeletedTx(ctx context.Context, ID uuid.UUID) (*Thunderbird, error) {
+	return svc.get(ctx, true, getDeletedThunderbirdStmt, ID)
+}
+
+// get fetches a single thunderbird from the db with the get statement name
+func (svc *thunderbirdService) get(ctx context.Context, useTx bool, name stmtName, ID uuid.UUID) (*Thunderbird, error) {
+	errMsg := func() string {
+		return "Error executing " + strings.ReplaceAll(name.String(), "-", " ") + " - " + fmt.Sprint(ID)
+	}
 
 	var (
 		stmt *sql.Stmt
 		err  error
 		tx   *sql.Tx
 	)
+	observe := onPrimary
 
 	if useTx {
 
@@ -77,15 +241,22 @@ func (svc *thunderbirdService) get(ctx context.Context, useTx bool, ID uuid.UUID
 			return nil, err
 		}
 
-		stmt = tx.Stmt(svc.stmts["get-thunderbird"])
+		stmt, err = svc.stmts.tx(tx, name)
 	} else {
-		stmt = svc.stmts["get-thunderbird"]
+		stmt, observe, err = svc.readStmt(ctx, name)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
 	}
 
-	p := Thunderbird{}
+	qctx, done := svc.hooks.start(ctx, name, ID)
+	row := stmt.QueryRowContext(qctx, ID)
+	done(nil, row.Err())
 
-	err = stmt.QueryRowContext(ctx, ID).
-		Scan(&m.ThunderbirdID, &m.Name)
+	p, err := scanThunderbird(row)
+	if observe(err) {
+		return svc.get(ReadYourWrites(ctx), useTx, name, ID)
+	}
 	if err != nil {
 
 		if errors.Is(err, sql.ErrNoRows) {
@@ -95,110 +266,311 @@ func (svc *thunderbirdService) get(ctx context.Context, useTx bool, ID uuid.UUID
 		return nil, errors.Wrap(err, errMsg())
 	}
 
-	return &p, nil
+	return p, nil
 }
 
 // Create a new thunderbird
 func (svc *thunderbirdService) Create(ctx context.Context, input *Thunderbird) error {
-	return svc.create(ctx, false, input)
+	return svc.retry.do(ctx, createThunderbirdStmt.String(), func() error {
+		_, err := svc.create(ctx, false, input, "", nil, 0)
+		return err
+	})
 }
 
 // CreateTx creates a new thunderbird withing a tx from ctx
 func (svc *thunderbirdService) CreateTx(ctx context.Context, input *Thunderbird) error {
-	return svc.create(ctx, true, input)
+	_, err := svc.create(ctx, true, input, "", nil, 0)
+	return err
 }
 
-// create a new thunderbird. if useTx = true then it will attempt to create the thunderbird within a transaction
-// from context.
-func (svc *thunderbirdService) create(ctx context.Context, useTx bool, input *Thunderbird) error {
-	errMsg := func() string { return "Error executing create thunderbird - " + fmt.Sprint(input) }
+// CreateIdempotent creates a new thunderbird unless key was used by another create
+// within the last ttl. A replayed key creates nothing, sets input to the current
+// state of the thunderbird the key created, even if it has since been soft deleted,
+// and returns false. A key replayed with a different requestHash, from
+// CreateRequestHash, than it was first used with fails with ErrIdempotencyKeyReused,
+// and one whose thunderbird has been purged with ErrNotFound.
+func (svc *thunderbirdService) CreateIdempotent(ctx context.Context, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error) {
+	var created bool
+	err := svc.retry.do(ctx, createThunderbirdStmt.String(), func() (err error) {
+		created, err = svc.create(ctx, false, input, key, requestHash, ttl)
+		return err
+	})
+	return created, err
+}
 
-	var (
-		stmt *sql.Stmt
-		err  error
-		tx   *sql.Tx
-	)
+// CreateIdempotentTx creates a new thunderbird unless key was recently used, within a tx from ctx
+func (svc *thunderbirdService) CreateIdempotentTx(ctx context.Context, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error) {
+	return svc.create(ctx, true, input, key, requestHash, ttl)
+}
 
-	if useTx {
+// create a new thunderbird, guarded by an idempotency key when key is set. if useTx = true then it will attempt
+// to create the thunderbird within a transaction from context. Returns whether a row was created.
+func (svc *thunderbirdService) create(ctx context.Context, useTx bool, input *Thunderbird, key string, requestHash []byte, ttl time.Duration) (bool, error) {
+	errMsg := func() string { return "Error executing create thunderbird - " + input.ID.String() }
 
-		if tx, err = FromCtx(ctx); err != nil {
-			return err
+	created := *input
+	created.Version = 1
+	event, err := newThunderbirdEvent(ThunderbirdCreatedType, &created)
+	if err != nil {
+		return false, errors.Wrap(err, errMsg())
+	}
+
+	replayed := false
+	err = svc.mutate(ctx, useTx, func(tx *sql.Tx) error {
+		if key != "" {
+			// a concurrent claim of the same key blocks here until the first commits
+			args := []interface{}{key, input.ID, requestHash, int64(ttl / time.Second)}
+			stmt, err := svc.stmts.tx(tx, claimIdempotencyKeyStmt)
+			if err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+
+			qctx, done := svc.hooks.start(ctx, claimIdempotencyKeyStmt, args...)
+			result, err := stmt.ExecContext(qctx, args...)
+			done(result, err)
+			if err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+
+			rowCount, err := result.RowsAffected()
+			if err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+
+			if rowCount == 0 {
+				replayed = true
+				stmt, err := svc.stmts.tx(tx, getIdempotencyKeyStmt)
+				if err != nil {
+					return errors.Wrap(err, errMsg())
+				}
+
+				qctx, done := svc.hooks.start(ctx, getIdempotencyKeyStmt, key)
+				row := stmt.QueryRowContext(qctx, key)
+				done(nil, row.Err())
+				var (
+					ID          uuid.UUID
+					name        sql.NullString
+					version     sql.NullInt64
+					createdAt   sql.NullTime
+					deletedAt   sql.NullTime
+					claimedHash []byte
+				)
+				if err = row.Scan(&ID, &name, &version, &createdAt, &deletedAt, &claimedHash); err != nil {
+					return errors.Wrap(err, errMsg())
+				}
+				// keys claimed before request hashes were stored are not checked
+				if claimedHash != nil && !bytes.Equal(claimedHash, requestHash) {
+					return errors.Wrap(ErrIdempotencyKeyReused, errMsg()+": "+key)
+				}
+				if !name.Valid {
+					return errors.Wrap(ErrNotFound, errMsg()+": "+ID.String())
+				}
+
+				*input = Thunderbird{ID: ID, Name: name.String, Version: version.Int64, CreatedAt: createdAt.Time}
+				if deletedAt.Valid {
+					input.DeletedAt = &deletedAt.Time
+				}
+				return nil
+			}
 		}
 
-		stmt = tx.Stmt(svc.stmts["create-thunderbird"])
-	} else {
-		stmt = svc.stmts["create-thunderbird"]
+		stmt, err := svc.stmts.tx(tx, createThunderbirdStmt)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		qctx, done := svc.hooks.start(ctx, createThunderbirdStmt, input.ID, input.Name)
+		result, err := stmt.ExecContext(qctx, input.ID, input.Name)
+		done(result, err)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		if rowCount == 0 {
+			return errors.Wrap(ErrNotCreated, errMsg())
+		}
+
+		return addOutboxEvent(ctx, tx, svc.stmts, svc.hooks, event)
+	})
+	if err != nil {
+		return false, err
 	}
+	return !replayed, nil
+}
+
+// CreateRequestHash hashes a create request without its request_id, so a replayed
+// idempotency key can be checked against the request it was first used with
+func CreateRequestHash(in *pb.CreateThunderbirdRequest) ([]byte, error) {
+	req := proto.Clone(in).(*pb.CreateThunderbirdRequest)
+	req.RequestId = ""
 
-	result, err := stmt.ExecContext(ctx, input.ThunderbirdID, input.Name)
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return nil, errors.Wrap(err, "Error hashing create thunderbird request")
 	}
 
-	rowCount, err := result.RowsAffected()
+	h := sha256.Sum256(b)
+	return h[:], nil
+}
+
+// PurgeIdempotencyKeys removes up to limit expired idempotency keys and returns
+// the number removed
+func (svc *thunderbirdService) PurgeIdempotencyKeys(ctx context.Context, limit int) (int, error) {
+	errMsg := func() string { return "Error executing purge idempotency keys - " + fmt.Sprint(limit) }
+
+	stmt, err := svc.stmts.get(purgeIdempotencyKeysStmt)
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return 0, errors.Wrap(err, errMsg())
 	}
 
-	if rowCount == 0 {
-		return errors.Wrap(ErrNotCreated, errMsg())
+	qctx, done := svc.hooks.start(ctx, purgeIdempotencyKeysStmt, limit)
+	result, err := stmt.ExecContext(qctx, limit)
+	done(result, err)
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg())
 	}
 
-	return nil
+	rowCount, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg())
+	}
+
+	return int(rowCount), nil
 }
 
-// Update updates a single thunderbird row in the DB
+// Update updates a single thunderbird row in the DB. If input.Version is set the
+// update only applies when the row is still at that version, otherwise ErrConflict
+// is returned. On success input.Version is set to the new version.
 func (svc *thunderbirdService) Update(ctx context.Context, input *Thunderbird) error {
-	return svc.update(ctx, false, input)
+	return svc.UpdateFields(ctx, input, ThunderbirdFields())
 }
 
 // UpdateTx updates a single thunderbird row in the DB within a tx from ctx
 func (svc *thunderbirdService) UpdateTx(ctx context.Context, input *Thunderbird) error {
-	return svc.update(ctx, true, input)
+	return svc.update(ctx, true, input, ThunderbirdFields())
 }
 
-// update a thunderbird. if useTx = true then it will attempt to update the thunderbird within a transaction
-// from context.
-func (svc *thunderbirdService) update(ctx context.Context, useTx bool, input *Thunderbird) error {
-	errMsg := func() string { return "Error executing update thunderbird - " + fmt.Sprint(input) }
+// UpdateFields updates only the named fields of a single thunderbird row, leaving
+// every other column as it is. Fields are named as in ThunderbirdFields, any other
+// name fails with ErrUnknownField. Versioning works as it does for Update.
+func (svc *thunderbirdService) UpdateFields(ctx context.Context, input *Thunderbird, fields []string) error {
+	return svc.retry.do(ctx, updateThunderbirdQueryName.String(), func() error {
+		return svc.update(ctx, false, input, fields)
+	})
+}
+
+// UpdateFieldsTx updates only the named fields of a single thunderbird row within a tx from ctx
+func (svc *thunderbirdService) UpdateFieldsTx(ctx context.Context, input *Thunderbird, fields []string) error {
+	return svc.update(ctx, true, input, fields)
+}
+
+// update the given fields of a thunderbird. if useTx = true then it will attempt to update the thunderbird
+// within a transaction from context.
+func (svc *thunderbirdService) update(ctx context.Context, useTx bool, input *Thunderbird, fields []string) error {
+	errMsg := func() string { return "Error executing update thunderbird - " + input.ID.String() }
+
+	if len(fields) == 0 {
+		return errors.Wrap(ErrUnknownField, errMsg()+": no fields to update")
+	}
+
+	// build the SET clause from whitelisted columns only, each field once
+	seen := map[string]bool{}
+	set := []string{}
+	args := []interface{}{}
+	for _, f := range fields {
+		column, ok := thunderbirdFields[f]
+		if !ok {
+			return errors.Wrap(ErrUnknownField, errMsg()+": "+f)
+		}
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		set = append(set, column+" = ?")
+		args = append(args, thunderbirdFieldValue(input, f))
+	}
+	query := fmt.Sprintf(updateThunderbirdQuery, strings.Join(set, ", "))
 
 	var (
-		stmt *sql.Stmt
-		err  error
-		tx   *sql.Tx
+		version int64
+		updated *Thunderbird
 	)
+	err := svc.mutate(ctx, useTx, func(tx *sql.Tx) error {
+		stmt, err := svc.stmts.tx(tx, lockThunderbirdStmt)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
 
-	if useTx {
+		qctx, done := svc.hooks.start(ctx, lockThunderbirdStmt, input.ID)
+		row := stmt.QueryRowContext(qctx, input.ID)
+		done(nil, row.Err())
 
-		if tx, err = FromCtx(ctx); err != nil {
-			return err
+		if err := row.Scan(&version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errors.Wrap(ErrNoRowsAffected, errMsg())
+			}
+			return errors.Wrap(err, errMsg())
 		}
 
-		stmt = tx.Stmt(svc.stmts["update-thunderbird"])
-	} else {
-		stmt = svc.stmts["update-thunderbird"]
-	}
+		if input.Version != 0 && input.Version != version {
+			return errors.Wrap(ErrConflict, errMsg())
+		}
 
-	result, err := stmt.ExecContext(ctx, input.Name, input.ThunderbirdID)
-	if err != nil {
-		return errors.Wrap(err, errMsg())
-	}
+		updateArgs := append(args, input.ID, version)
+		qctx, done = svc.hooks.start(ctx, updateThunderbirdQueryName, updateArgs...)
+		result, err := tx.ExecContext(qctx, query, updateArgs...)
+		done(result, err)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
 
-	rowCount, err := result.RowsAffected()
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		if rowCount == 0 {
+			return errors.Wrap(ErrNoRowsAffected, errMsg())
+		}
+
+		// read the row back for the event, as input only holds the fields in the mask
+		stmt, err = svc.stmts.tx(tx, getThunderbirdStmt)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		qctx, done = svc.hooks.start(ctx, getThunderbirdStmt, input.ID)
+		row = stmt.QueryRowContext(qctx, input.ID)
+		done(nil, row.Err())
+		if updated, err = scanThunderbird(row); err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		event, err := newThunderbirdEvent(ThunderbirdUpdatedType, updated)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+		return addOutboxEvent(ctx, tx, svc.stmts, svc.hooks, event)
+	})
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return err
 	}
 
-	if rowCount == 0 {
-		return errors.Wrap(ErrNoRowsAffected, errMsg())
-	}
+	input.Version = updated.Version
 
 	return nil
 }
 
 // Delete sets deleted_at for a single thunderbirds row
 func (svc *thunderbirdService) Delete(ctx context.Context, ID uuid.UUID) error {
-	return svc.delete(ctx, false, ID)
+	return svc.retry.do(ctx, deleteThunderbirdStmt.String(), func() error {
+		return svc.delete(ctx, false, ID)
+	})
 }
 
 // DeleteTx sets deleted_at for a single thunderbirds row within a tx from ctx
@@ -211,37 +583,383 @@ func (svc *thunderbirdService) DeleteTx(ctx context.Context, ID uuid.UUID) error
 func (svc *thunderbirdService) delete(ctx context.Context, useTx bool, ID uuid.UUID) error {
 	errMsg := func() string { return "Error executing delete thunderbird - " + ID.String() }
 
+	event, err := newThunderbirdEvent(ThunderbirdDeletedType, &Thunderbird{ID: ID})
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	err = svc.mutate(ctx, useTx, func(tx *sql.Tx) error {
+		stmt, err := svc.stmts.tx(tx, deleteThunderbirdStmt)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		qctx, done := svc.hooks.start(ctx, deleteThunderbirdStmt, ID)
+		result, err := stmt.ExecContext(qctx, ID)
+		done(result, err)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		if rowCount == 0 {
+			return errors.Wrap(ErrNotFound, errMsg())
+		}
+
+		return addOutboxEvent(ctx, tx, svc.stmts, svc.hooks, event)
+	})
+	return err
+}
+
+// Restore clears deleted_at for a single soft deleted thunderbirds row
+func (svc *thunderbirdService) Restore(ctx context.Context, ID uuid.UUID) error {
+	return svc.retry.do(ctx, restoreThunderbirdStmt.String(), func() error {
+		return svc.restore(ctx, false, ID)
+	})
+}
+
+// RestoreTx clears deleted_at for a single soft deleted thunderbirds row within a tx from ctx
+func (svc *thunderbirdService) RestoreTx(ctx context.Context, ID uuid.UUID) error {
+	return svc.restore(ctx, true, ID)
+}
+
+// restore a soft deleted thunderbird by clearing deleted at. if useTx = true then it will attempt to restore the
+// thunderbird within a transaction from context.
+func (svc *thunderbirdService) restore(ctx context.Context, useTx bool, ID uuid.UUID) error {
+	errMsg := func() string { return "Error executing restore thunderbird - " + ID.String() }
+
+	event, err := newThunderbirdEvent(ThunderbirdRestoredType, &Thunderbird{ID: ID})
+	if err != nil {
+		return errors.Wrap(err, errMsg())
+	}
+
+	err = svc.mutate(ctx, useTx, func(tx *sql.Tx) error {
+		stmt, err := svc.stmts.tx(tx, restoreThunderbirdStmt)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		qctx, done := svc.hooks.start(ctx, restoreThunderbirdStmt, ID)
+		result, err := stmt.ExecContext(qctx, ID)
+		done(result, err)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		rowCount, err := result.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		if rowCount == 0 {
+			return errors.Wrap(ErrNotFound, errMsg())
+		}
+
+		return addOutboxEvent(ctx, tx, svc.stmts, svc.hooks, event)
+	})
+	return err
+}
+
+// Purge permanently removes up to limit thunderbirds that were soft deleted before
+// deletedBefore, oldest first, in a single tx. Returns the number of rows removed.
+func (svc *thunderbirdService) Purge(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	errMsg := func() string { return "Error executing purge thunderbirds - " + deletedBefore.String() }
+
+	IDs := []uuid.UUID{}
+	err := svc.mutate(ctx, false, func(tx *sql.Tx) error {
+		stmt, err := svc.stmts.tx(tx, listPurgeableThunderbirdsStmt)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		qctx, done := svc.hooks.start(ctx, listPurgeableThunderbirdsStmt, deletedBefore, limit)
+		rows, err := stmt.QueryContext(qctx, deletedBefore, limit)
+		done(nil, err)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+		for rows.Next() {
+			var ID uuid.UUID
+			if err = rows.Scan(&ID); err != nil {
+				rows.Close()
+				return errors.Wrap(err, errMsg())
+			}
+			IDs = append(IDs, ID)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+
+		stmt, err = svc.stmts.tx(tx, purgeThunderbirdStmt)
+		if err != nil {
+			return errors.Wrap(err, errMsg())
+		}
+		for _, ID := range IDs {
+			qctx, done := svc.hooks.start(ctx, purgeThunderbirdStmt, ID)
+			result, err := stmt.ExecContext(qctx, ID)
+			done(result, err)
+			if err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+
+			event, err := newThunderbirdEvent(ThunderbirdPurgedType, &Thunderbird{ID: ID})
+			if err != nil {
+				return errors.Wrap(err, errMsg())
+			}
+			if err = addOutboxEvent(ctx, tx, svc.stmts, svc.hooks, event); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(IDs), nil
+}
+
+// ThunderbirdOrder is a column that thunderbirds can be listed by
+type ThunderbirdOrder int
+
+const (
+	// OrderByName lists thunderbirds by name
+	OrderByName ThunderbirdOrder = iota
+	// OrderByCreatedAt lists thunderbirds by creation time
+	OrderByCreatedAt
+)
+
+// ThunderbirdCursor is the keyset position of a row within a listing.
+// Only the field matching the listing order is compared, along with ID.
+type ThunderbirdCursor struct {
+	Name      string
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// Cursor returns the keyset position of a thunderbird
+func (m *Thunderbird) Cursor() *ThunderbirdCursor {
+	return &ThunderbirdCursor{
+		Name:      m.Name,
+		CreatedAt: m.CreatedAt,
+		ID:        m.ID,
+	}
+}
+
+// ListThunderbirdsParams filters, orders and positions a thunderbird listing
+type ListThunderbirdsParams struct {
+	// Limit is the maximum number of rows returned
+	Limit int
+	// NamePrefix only matches thunderbirds whose name starts with it
+	NamePrefix string
+	// OrderBy is the column rows are sorted by, ties are broken by ID
+	OrderBy ThunderbirdOrder
+	// Descending reverses the sort order
+	Descending bool
+	// IncludeDeleted includes soft deleted rows
+	IncludeDeleted bool
+	// After starts the listing after the given position, nil starts at the beginning
+	After *ThunderbirdCursor
+}
+
+// List fetches a page of thunderbirds from the db
+func (svc *thunderbirdService) List(ctx context.Context, params *ListThunderbirdsParams) ([]*Thunderbird, error) {
+	var results []*Thunderbird
+	err := svc.retry.do(ctx, listThunderbirdsStmt(params.OrderBy, params.Descending).String(), func() (err error) {
+		results, err = svc.list(ctx, false, params)
+		return err
+	})
+	return results, err
+}
+
+// ListTx fetches a page of thunderbirds from the db inside of a tx from ctx
+func (svc *thunderbirdService) ListTx(ctx context.Context, params *ListThunderbirdsParams) ([]*Thunderbird, error) {
+	return svc.list(ctx, true, params)
+}
+
+// list fetches a page of thunderbirds using keyset pagination so that later pages
+// do not scan the rows of earlier ones. if useTx = true then it will attempt to list
+// within a transaction from context.
+func (svc *thunderbirdService) list(ctx context.Context, useTx bool, params *ListThunderbirdsParams) ([]*Thunderbird, error) {
+	errMsg := func() string { return "Error executing list thunderbirds - " + fmt.Sprintf("%+v", *params) }
+
 	var (
 		stmt *sql.Stmt
 		err  error
 		tx   *sql.Tx
 	)
 
+	name := listThunderbirdsStmt(params.OrderBy, params.Descending)
+	observe := onPrimary
+
 	if useTx {
 
 		if tx, err = FromCtx(ctx); err != nil {
-			return err
+			return nil, err
 		}
 
-		stmt = tx.Stmt(svc.stmts["delete-thunderbird"])
+		stmt, err = svc.stmts.tx(tx, name)
 	} else {
-		stmt = svc.stmts["delete-thunderbird"]
+		stmt, observe, err = svc.readStmt(ctx, name)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	first := params.After == nil
+	after := params.After
+	if first {
+		after = &ThunderbirdCursor{}
+	}
+
+	var position interface{} = after.Name
+	if params.OrderBy == OrderByCreatedAt {
+		position = after.CreatedAt
 	}
 
-	result, err := stmt.ExecContext(ctx, ID)
+	args := []interface{}{
+		params.IncludeDeleted,
+		escapeLike(params.NamePrefix),
+		first, position, after.ID,
+		params.Limit,
+	}
+	qctx, done := svc.hooks.start(ctx, name, args...)
+	rows, err := stmt.QueryContext(qctx, args...)
+	done(nil, err)
+	if observe(err) {
+		return svc.list(ReadYourWrites(ctx), useTx, params)
+	}
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return nil, errors.Wrap(err, errMsg())
 	}
+	defer rows.Close()
 
-	rowCount, err := result.RowsAffected()
+	results := []*Thunderbird{}
+	for rows.Next() {
+		p, err := scanThunderbird(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, errMsg())
+		}
+		results = append(results, p)
+	}
+	err = rows.Err()
+	if observe(err) {
+		return svc.list(ReadYourWrites(ctx), useTx, params)
+	}
 	if err != nil {
-		return errors.Wrap(err, errMsg())
+		return nil, errors.Wrap(err, errMsg())
 	}
 
-	if rowCount == 0 {
-		return errors.Wrap(ErrNotFound, errMsg())
+	return results, nil
+}
+
+// listThunderbirdsStmt picks the prepared list statement for an ordering. The
+// ORDER BY clause cannot be a placeholder so each ordering is its own statement.
+func listThunderbirdsStmt(order ThunderbirdOrder, descending bool) stmtName {
+	if order == OrderByCreatedAt {
+		if descending {
+			return listThunderbirdsByCreatedAtDescStmt
+		}
+		return listThunderbirdsByCreatedAtStmt
 	}
+	if descending {
+		return listThunderbirdsByNameDescStmt
+	}
+	return listThunderbirdsByNameStmt
+}
 
-	return nil
+// escapeLike escapes the LIKE wildcards in s so it is matched literally
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Load fetches every thunderbird in IDs from the db in a single query. Rows
+// are returned in no particular order and missing IDs are omitted.
+func (svc *thunderbirdService) Load(ctx context.Context, IDs []uuid.UUID) ([]*Thunderbird, error) {
+	var results []*Thunderbird
+	err := svc.retry.do(ctx, loadThunderbirdsQueryName.String(), func() (err error) {
+		results, err = svc.load(ctx, false, IDs)
+		return err
+	})
+	return results, err
+}
+
+// LoadTx fetches every thunderbird in IDs from the db inside of a tx from ctx
+func (svc *thunderbirdService) LoadTx(ctx context.Context, IDs []uuid.UUID) ([]*Thunderbird, error) {
+	return svc.load(ctx, true, IDs)
 }
 
+// load fetches a batch of thunderbirds by ID. if useTx = true then it will attempt
+// to load within a transaction from context.
+func (svc *thunderbirdService) load(ctx context.Context, useTx bool, IDs []uuid.UUID) ([]*Thunderbird, error) {
+	errMsg := func() string { return "Error executing load thunderbirds - " + fmt.Sprint(IDs) }
+
+	if len(IDs) == 0 {
+		return []*Thunderbird{}, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("UUID_TO_BIN(?), ", len(IDs)), ", ")
+	query := fmt.Sprintf(loadThunderbirdsQuery, placeholders)
+
+	args := make([]interface{}, len(IDs))
+	for i, ID := range IDs {
+		args[i] = ID
+	}
+
+	var (
+		rows *sql.Rows
+		err  error
+		tx   *sql.Tx
+	)
+
+	if useTx {
+
+		if tx, err = FromCtx(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	observe := onPrimary
+	qctx, done := svc.hooks.start(ctx, loadThunderbirdsQueryName, args...)
+	if useTx {
+		rows, err = tx.QueryContext(qctx, query, args...)
+	} else {
+		var reader *sql.DB
+		reader, observe = svc.readDB(ctx)
+		rows, err = reader.QueryContext(qctx, query, args...)
+	}
+	done(nil, err)
+	if observe(err) {
+		return svc.load(ReadYourWrites(ctx), useTx, IDs)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+	defer rows.Close()
+
+	results := make([]*Thunderbird, 0, len(IDs))
+	for rows.Next() {
+		p, err := scanThunderbird(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, errMsg())
+		}
+		results = append(results, p)
+	}
+	err = rows.Err()
+	if observe(err) {
+		return svc.load(ReadYourWrites(ctx), useTx, IDs)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg())
+	}
+
+	return results, nil
+}
@@ -1,346 +1,826 @@
 package db
 
 import (
-  "context"
-  "database/sql"
-  "database/sql/driver"
-  "testing"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
 
-  "github.com/DATA-DOG/go-sqlmock"
-  "github.com/google/uuid"
-  "github.com/stretchr/testify/assert"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 
-  "github.com/caring/ford-thunderbird/pb"
+	"github.com/caring/ford-thunderbird/pb"
 )
 
-
-
 // ensures that casting from proto to store structs occurs correctly
 func TestNewThunderbird(t *testing.T) {
-  thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  proto := pb.CreateThunderbirdRequest{
-    Name:       "Foobar",
-  }
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	proto := pb.CreateThunderbirdRequest{
+		Name: "Foobar",
+	}
 
-  r, err := NewThunderbird(thunderbirdID.String(), &proto)
+	r, err := NewThunderbird(thunderbirdID.String(), &proto)
 
-  assert.NoError(t, err, "Expected NewCategory not to error")
-  assert.Equal(t, thunderbirdID, r.ID, "Expected UUIDs to match")
-  assert.Equal(t, proto.Name, r.Name, "Expected name to be correctly assigned")
+	assert.NoError(t, err, "Expected NewCategory not to error")
+	assert.Equal(t, thunderbirdID, r.ID, "Expected UUIDs to match")
+	assert.Equal(t, proto.Name, r.Name, "Expected name to be correctly assigned")
 }
 
 // ensures that casting from store to proto response occurs correctly
 func TestThunderbird_ToProto(t *testing.T) {
-  thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
 
-  thunderbird := &Thunderbird{
-    ID:  thunderbirdID,
-    Name:       "foobar",
-  }
+	thunderbird := &Thunderbird{
+		ID:   thunderbirdID,
+		Name: "foobar",
+	}
 
-  r := thunderbird.ToProto()
+	r := thunderbird.ToProto()
 
-  assert.Equal(t, thunderbirdID.String(), r.ThunderbirdId, "Expected field to be mapped back to proto object correctly")
-  assert.Equal(t, "foobar", r.Name, "Expected field to be mapped back to proto object correctly")
+	assert.Equal(t, thunderbirdID.String(), r.Id, "Expected field to be mapped back to proto object correctly")
+	assert.Equal(t, "foobar", r.Name, "Expected field to be mapped back to proto object correctly")
 }
 
 func TestThunderbirdService_get(t *testing.T) {
-  thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "get-thunderbird": "SELECT thunderbirds",
-  }
-  args := []driver.Value{
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-  }
-
-  // ensures execution within a transaction occurs without error and the correct result is returned
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectQuery("SELECT thunderbirds").
-      WithArgs(args...).
-      WillReturnRows(
-        sqlmock.NewRows([]string{"thunderbird_id", "name"}).
-          AddRow(thunderbirdID, "Foobar"),
-      )
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    result, err := store.Thunderbird.GetTx(ToCtx(context.Background(), tx), thunderbirdID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    assert.Equal(t, thunderbirdID, r.ID, "Expected correct thunderbird ID to be returned")
-    assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that execution outside of transaction occurs without error and the correct result is returned
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectQuery("SELECT thunderbirds").
-      WithArgs(args...).
-      WillReturnRows(
-        sqlmock.NewRows([]string{"thunderbird_id", "name"}).
-          AddRow(thunderbirdID, "Foobar"),
-      )
-
-    result, err := store.Thunderbird.Get(context.Background(), thunderbirdID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    assert.Equal(t, thunderbirdID, r.ID, "Expected correct thunderbird ID to be returned")
-    assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures a record not found is handled correctly
-  t.Run("No rows returned", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectQuery("SELECT thunderbirds").
-      WithArgs(args...).WillReturnError(sql.ErrNoRows)
-
-    _, err = store.Thunderbird.Get(context.Background(), thunderbirdID)
-    assert.EqualError(t, err, "Error executing get thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"get-thunderbird": "SELECT thunderbirds",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures execution within a transaction occurs without error and the correct result is returned
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}).
+					AddRow(thunderbirdID, "Foobar", 1, createdAt, nil),
+			)
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		r, err := store.Thunderbird.GetTx(ToCtx(context.Background(), tx), thunderbirdID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Equal(t, thunderbirdID, r.ID, "Expected correct thunderbird ID to be returned")
+		assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
+		assert.Equal(t, int64(1), r.Version, "Expected correct version to be returned")
+		assert.Equal(t, createdAt, r.CreatedAt, "Expected correct created at to be returned")
+		assert.Nil(t, r.DeletedAt, "Expected deleted at to be empty")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that execution outside of transaction occurs without error and the correct result is returned
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}).
+					AddRow(thunderbirdID, "Foobar", 1, createdAt, nil),
+			)
+
+		r, err := store.Thunderbird.Get(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		assert.Equal(t, thunderbirdID, r.ID, "Expected correct thunderbird ID to be returned")
+		assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
+		assert.Equal(t, createdAt, r.CreatedAt, "Expected correct created at to be returned")
+		assert.Nil(t, r.DeletedAt, "Expected deleted at to be empty")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a record not found is handled correctly
+	t.Run("No rows returned", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs(args...).WillReturnError(sql.ErrNoRows)
+
+		_, err = store.Thunderbird.Get(context.Background(), thunderbirdID)
+		assert.EqualError(t, err, "Error executing get thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a soft deleted record is read with its own statement and returns its deletion time
+	t.Run("Deleted record", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{
+			"get-deleted-thunderbird": "SELECT deleted thunderbirds",
+		})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		deletedAt := createdAt.Add(time.Hour)
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT deleted thunderbirds").
+			WithArgs(args...).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}).
+					AddRow(thunderbirdID, "Foobar", 1, createdAt, deletedAt),
+			)
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		r, err := store.Thunderbird.GetDeletedTx(ToCtx(context.Background(), tx), thunderbirdID)
+		assert.NoError(t, err, "Expecting no query error")
+		if assert.NotNil(t, r.DeletedAt, "Expected deleted at to be set") {
+			assert.Equal(t, deletedAt, *r.DeletedAt, "Expected correct deleted at to be returned")
+		}
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
 }
 
 func TestThunderbirdService_create(t *testing.T) {
-  thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "create-thunderbird": "INSERT thunderbirds",
-  }
-  input := &Thunderbird{
-    ID:   thunderbirdID,
-    Name: "Foobar",
-  }
-  args := []driver.Value{
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-    "Foobar",
-  }
-
-  // ensures that execution within a transaction occurs without error
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectExec("INSERT thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    err = store.Thunderbird.CreateTx(ToCtx(context.Background(), tx), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that execution outside of a transaction occurs without error
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("INSERT thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    err = store.Thunderbird.Create(context.Background(), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that a failed record create is handled correctly
-  t.Run("Failed record create", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("INSERT thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 0))
-
-    err = store.Thunderbird.Create(context.Background(), input)
-    assert.EqualError(t, err, "Error executing create thunderbird - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar}: no new rows were created", "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"create-thunderbird":  "INSERT thunderbirds",
+		"create-outbox-event": "INSERT outbox",
+	}
+	input := &Thunderbird{
+		ID:   thunderbirdID,
+		Name: "Foobar",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		"Foobar",
+	}
+
+	// ensures that execution within a transaction occurs without error
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.created", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		err = store.Thunderbird.CreateTx(ToCtx(context.Background(), tx), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that execution outside of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.created", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = store.Thunderbird.Create(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that a failed record create is handled correctly
+	t.Run("Failed record create", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err = store.Thunderbird.Create(context.Background(), input)
+		assert.EqualError(t, err, "Error executing create thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: no new rows were created", "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestThunderbirdService_createIdempotent(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	originalID := uuid.MustParse("0b4c3f1e-3b1f-4f4e-9a51-6f0f3e1f2a10")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	foobarHash, bazquxHash := []byte("foobar-hash"), []byte("bazqux-hash")
+	stmt := map[string]string{
+		"claim-idempotency-key": "INSERT idempotency_keys",
+		"get-idempotency-key":   "SELECT thunderbird_id",
+		"create-thunderbird":    "INSERT thunderbirds",
+		"create-outbox-event":   "INSERT outbox",
+	}
+
+	// ensures that a new key is claimed and the thunderbird created
+	t.Run("New key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT idempotency_keys").
+			WithArgs("req-1", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", foobarHash, 3600).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT thunderbirds").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "Foobar").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.created", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		input := &Thunderbird{ID: thunderbirdID, Name: "Foobar"}
+		created, err := store.Thunderbird.CreateIdempotent(context.Background(), input, "req-1", foobarHash, time.Hour)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.True(t, created, "Expecting the thunderbird to be created")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that a replayed key returns the original thunderbird without creating
+	t.Run("Replayed key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT idempotency_keys").
+			WithArgs("req-1", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", foobarHash, 3600).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT thunderbird_id").
+			WithArgs("req-1").
+			WillReturnRows(sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at", "request_hash"}).AddRow(originalID[:], "Foobar", 2, createdAt, nil, foobarHash))
+		mock.ExpectCommit()
+
+		input := &Thunderbird{ID: thunderbirdID, Name: "Foobar"}
+		created, err := store.Thunderbird.CreateIdempotent(context.Background(), input, "req-1", foobarHash, time.Hour)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.False(t, created, "Expecting nothing to be created")
+		assert.Equal(t, &Thunderbird{ID: originalID, Name: "Foobar", Version: 2, CreatedAt: createdAt}, input, "Expecting the original thunderbird to be set")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that a replayed key whose thunderbird was purged is not found
+	t.Run("Purged thunderbird", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT idempotency_keys").
+			WithArgs("req-1", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", foobarHash, 3600).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT thunderbird_id").
+			WithArgs("req-1").
+			WillReturnRows(sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at", "request_hash"}).AddRow(originalID[:], nil, nil, nil, nil, foobarHash))
+		mock.ExpectRollback()
+
+		input := &Thunderbird{ID: thunderbirdID, Name: "Foobar"}
+		created, err := store.Thunderbird.CreateIdempotent(context.Background(), input, "req-1", foobarHash, time.Hour)
+		assert.ErrorIs(t, err, ErrNotFound, "Expecting the purged thunderbird not to be found")
+		assert.False(t, created, "Expecting nothing to be created")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that a key replayed with a different request is rejected
+	t.Run("Reused key", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT idempotency_keys").
+			WithArgs("req-1", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", bazquxHash, 3600).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT thunderbird_id").
+			WithArgs("req-1").
+			WillReturnRows(sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at", "request_hash"}).AddRow(originalID[:], "Foobar", 1, createdAt, nil, foobarHash))
+		mock.ExpectRollback()
+
+		input := &Thunderbird{ID: thunderbirdID, Name: "Bazqux"}
+		created, err := store.Thunderbird.CreateIdempotent(context.Background(), input, "req-1", bazquxHash, time.Hour)
+		assert.ErrorIs(t, err, ErrIdempotencyKeyReused, "Expecting the reused key to be rejected")
+		assert.False(t, created, "Expecting nothing to be created")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestCreateRequestHash(t *testing.T) {
+	hash := func(in *pb.CreateThunderbirdRequest) []byte {
+		h, err := CreateRequestHash(in)
+		assert.NoError(t, err, "Expected no error")
+		return h
+	}
+
+	// ensures the request_id is left out of the hash and the rest of the request is not
+	t.Run("Request fields", func(t *testing.T) {
+		foobar := hash(&pb.CreateThunderbirdRequest{Name: "Foobar", RequestId: "req-1"})
+		assert.Equal(t, foobar, hash(&pb.CreateThunderbirdRequest{Name: "Foobar", RequestId: "req-2"}), "Expected the request_id not to be hashed")
+		assert.NotEqual(t, foobar, hash(&pb.CreateThunderbirdRequest{Name: "Bazqux", RequestId: "req-1"}), "Expected the name to be hashed")
+	})
 }
 
 func TestThunderbirdService_update(t *testing.T) {
-  thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "update-thunderbird": "UPDATE thunderbirds",
-  }
-  input := &Thunderbird{
-    ID:   thunderbirdID,
-    Name: "Foobar",
-  }
-  args := []driver.Value{
-    "Foobar",
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-  }
-
-  // ensures that execution within a transaction occurs without error
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectExec("UPDATE thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    err = store.Thunderbird.UpdateTx(ToCtx(context.Background(), tx), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures execution out of a transaction occurs without error
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    err = store.Thunderbird.Update(context.Background(), input)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures correct error to be returned when no rows are updated
-  t.Run("No updates occurred", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 0))
-
-    err = store.Thunderbird.Update(context.Background(), input)
-    assert.EqualError(t, err, "Error executing update thunderbird - &{72bc87f3-4a9f-4d05-93fe-844d3cd94c65 Foobar}: no rows affected", "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"lock-thunderbird":    "SELECT version",
+		"get-thunderbird":     "SELECT thunderbirds",
+		"create-outbox-event": "INSERT outbox",
+	}
+	args := []driver.Value{
+		"Foobar",
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+		2,
+	}
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	updated := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}).
+			AddRow(thunderbirdID, "Foobar", 3, createdAt, nil)
+	}
+
+	// ensures that execution within a transaction occurs without error
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT version").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(updated())
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.updated", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		input := &Thunderbird{ID: thunderbirdID, Name: "Foobar", Version: 2}
+		err = store.Thunderbird.UpdateTx(ToCtx(context.Background(), tx), input)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, int64(3), input.Version, "Expecting the new version to be set")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures execution out of a transaction without a version updates unconditionally
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT version").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT thunderbirds").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(updated())
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.updated",
+				[]byte(`{"thunderbird_id":"72bc87f3-4a9f-4d05-93fe-844d3cd94c65","name":"Foobar","version":3,"created_at":"2020-01-02T03:04:05Z"}`)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		input := &Thunderbird{ID: thunderbirdID, Name: "Foobar"}
+		err = store.Thunderbird.Update(context.Background(), input)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, int64(3), input.Version, "Expecting the new version to be set")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures a stale version is rejected without writing
+	t.Run("Stale version", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT version").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+		mock.ExpectRollback()
+
+		err = store.Thunderbird.Update(context.Background(), &Thunderbird{ID: thunderbirdID, Name: "Foobar", Version: 2})
+		assert.EqualError(t, err, "Error executing update thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record has been modified since it was read", "Expecting conflict error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that only whitelisted fields may be written
+	t.Run("Unknown field", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		err = store.Thunderbird.UpdateFields(context.Background(), &Thunderbird{ID: thunderbirdID, Name: "Foobar"}, []string{"name", "thunderbird_id"})
+		assert.EqualError(t, err, "Error executing update thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: thunderbird_id: unknown or read only field", "Expecting unknown field error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures correct error to be returned when the record does not exist
+	t.Run("No updates occurred", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT version").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectRollback()
+
+		err = store.Thunderbird.Update(context.Background(), &Thunderbird{ID: thunderbirdID, Name: "Foobar"})
+		assert.EqualError(t, err, "Error executing update thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: no rows affected", "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
 }
 
 func TestThunderbirdService_delete(t *testing.T) {
-  thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
-  stmt := map[string]string{
-    "delete-thunderbird": "UPDATE thunderbirds",
-  }
-  args := []driver.Value{
-    "72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
-  }
-
-  // ensures that execution withing a transaction occurs without error
-  t.Run("With a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectBegin()
-    mock.ExpectExec("UPDATE thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    tx, err := store.GetTx()
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "transaction setup failed")
-    }
-
-    err = store.Thunderbird.DeleteTx(ToCtx(context.Background(), tx), thunderbirdID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that execution outside of a transaction occurs without error
-  t.Run("Without a provided transaction", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 1))
-
-    err = store.Thunderbird.Delete(context.Background(), thunderbirdID)
-    assert.NoError(t, err, "Expecting no query error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-
-  // ensures that deleting a non existent record is handled correctly
-  t.Run("Deleting a non existent record", func(t *testing.T) {
-    store, mock, err := NewTestDB(stmt)
-    if ok := assert.NoError(t, err, "Expected no error"); !ok {
-      assert.FailNow(t, "test setup failed")
-    }
-
-    mock.ExpectExec("UPDATE thunderbirds").
-      WithArgs(args...).
-      WillReturnResult(sqlmock.NewResult(0, 0))
-
-    err = store.Thunderbird.Delete(context.Background(), thunderbirdID)
-    assert.EqualError(t, err, "Error executing delete thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting not found error")
-
-    err = mock.ExpectationsWereMet()
-    assert.NoError(t, err, "Expecting all mock conditions to be met")
-  })
-}
\ No newline at end of file
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"delete-thunderbird":  "UPDATE thunderbirds",
+		"create-outbox-event": "INSERT outbox",
+	}
+	args := []driver.Value{
+		"72bc87f3-4a9f-4d05-93fe-844d3cd94c65",
+	}
+
+	// ensures that execution withing a transaction occurs without error
+	t.Run("With a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.deleted", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		tx, err := store.GetTx()
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "transaction setup failed")
+		}
+
+		err = store.Thunderbird.DeleteTx(ToCtx(context.Background(), tx), thunderbirdID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that execution outside of a transaction occurs without error
+	t.Run("Without a provided transaction", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.deleted", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that deleting a non existent record is handled correctly
+	t.Run("Deleting a non existent record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs(args...).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err = store.Thunderbird.Delete(context.Background(), thunderbirdID)
+		assert.EqualError(t, err, "Error executing delete thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestThunderbirdService_restore(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"restore-thunderbird": "UPDATE thunderbirds",
+		"create-outbox-event": "INSERT outbox",
+	}
+
+	// ensures that a soft deleted record is restored and an event recorded
+	t.Run("Restoring a deleted record", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.restored", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = store.Thunderbird.Restore(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expecting no query error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that restoring a record that is not deleted is handled correctly
+	t.Run("Restoring a record that is not deleted", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err = store.Thunderbird.Restore(context.Background(), thunderbirdID)
+		assert.EqualError(t, err, "Error executing restore thunderbird - 72bc87f3-4a9f-4d05-93fe-844d3cd94c65: the record you are attempting to find or update is not found", "Expecting not found error")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestThunderbirdService_purge(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	cutoff := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	stmt := map[string]string{
+		"list-purgeable-thunderbirds": "SELECT thunderbird_id",
+		"purge-thunderbird":           "DELETE FROM thunderbirds",
+		"create-outbox-event":         "INSERT outbox",
+	}
+
+	// ensures that expired records are removed and an event recorded for each
+	t.Run("Expired records", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT thunderbird_id").
+			WithArgs(cutoff, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"thunderbird_id"}).AddRow(thunderbirdID[:]))
+		mock.ExpectExec("DELETE FROM thunderbirds").
+			WithArgs("72bc87f3-4a9f-4d05-93fe-844d3cd94c65").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WithArgs("thunderbird", "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "thunderbird.purged", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		n, err := store.Thunderbird.Purge(context.Background(), cutoff, 100)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, 1, n, "Expecting one record to be purged")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures that nothing is removed when no records have expired
+	t.Run("No expired records", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT thunderbird_id").
+			WithArgs(cutoff, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"thunderbird_id"}))
+		mock.ExpectCommit()
+
+		n, err := store.Thunderbird.Purge(context.Background(), cutoff, 100)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Equal(t, 0, n, "Expecting no records to be purged")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestThunderbirdService_list(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	deletedAt := time.Date(2020, 2, 3, 4, 5, 6, 0, time.UTC)
+	stmt := map[string]string{
+		"list-thunderbirds-by-name":            "SELECT thunderbirds BY name",
+		"list-thunderbirds-by-created-at-desc": "SELECT thunderbirds BY created_at DESC",
+	}
+	columns := []string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}
+
+	// ensures the first page is requested without a cursor and rows are scanned in order
+	t.Run("First page", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT thunderbirds BY name").
+			WithArgs(false, `foo\_`, true, "", "00000000-0000-0000-0000-000000000000", 2).
+			WillReturnRows(
+				sqlmock.NewRows(columns).
+					AddRow(thunderbirdID, "foo_bar", 1, createdAt, nil).
+					AddRow(thunderbirdID, "foo_baz", 2, createdAt, deletedAt),
+			)
+
+		r, err := store.Thunderbird.List(context.Background(), &ListThunderbirdsParams{
+			Limit:      2,
+			NamePrefix: "foo_",
+		})
+		assert.NoError(t, err, "Expecting no query error")
+
+		if assert.Len(t, r, 2, "Expected both rows to be returned") {
+			assert.Equal(t, "foo_bar", r[0].Name, "Expected rows in query order")
+			assert.Nil(t, r[0].DeletedAt, "Expected deleted at to be empty")
+			assert.Equal(t, "foo_baz", r[1].Name, "Expected rows in query order")
+			assert.Equal(t, deletedAt, *r[1].DeletedAt, "Expected deleted at to be scanned")
+		}
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures later pages pass the cursor position for the requested ordering
+	t.Run("After a cursor", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery("SELECT thunderbirds BY created_at DESC").
+			WithArgs(true, "", false, createdAt, "72bc87f3-4a9f-4d05-93fe-844d3cd94c65", 10).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		r, err := store.Thunderbird.List(context.Background(), &ListThunderbirdsParams{
+			Limit:          10,
+			OrderBy:        OrderByCreatedAt,
+			Descending:     true,
+			IncludeDeleted: true,
+			After:          &ThunderbirdCursor{CreatedAt: createdAt, ID: thunderbirdID},
+		})
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Empty(t, r, "Expected no rows to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
+
+func TestThunderbirdService_load(t *testing.T) {
+	firstID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	secondID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	createdAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// ensures every id is bound to its own placeholder in a single query
+	t.Run("Multiple IDs", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		mock.ExpectQuery(`thunderbird_id IN \(UUID_TO_BIN\(\?\), UUID_TO_BIN\(\?\)\)`).
+			WithArgs(firstID.String(), secondID.String()).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"thunderbird_id", "name", "version", "created_at", "deleted_at"}).
+					AddRow(secondID, "Bazqux", 1, createdAt, nil),
+			)
+
+		r, err := store.Thunderbird.Load(context.Background(), []uuid.UUID{firstID, secondID})
+		assert.NoError(t, err, "Expecting no query error")
+
+		if assert.Len(t, r, 1, "Expected only found rows to be returned") {
+			assert.Equal(t, secondID, r[0].ID, "Expected correct thunderbird ID to be returned")
+		}
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+
+	// ensures no query is run for an empty batch
+	t.Run("No IDs", func(t *testing.T) {
+		store, mock, err := NewTestDB(map[string]string{})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+
+		r, err := store.Thunderbird.Load(context.Background(), nil)
+		assert.NoError(t, err, "Expecting no query error")
+		assert.Empty(t, r, "Expected no rows to be returned")
+
+		err = mock.ExpectationsWereMet()
+		assert.NoError(t, err, "Expecting all mock conditions to be met")
+	})
+}
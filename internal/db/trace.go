@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// Tags set on the span of each statement by SpanHook
+const (
+	statementNameTag = "db.statement_name"
+	rowsAffectedTag  = "db.rows_affected"
+)
+
+// SpanHook wraps each statement in a span named after it, a child of the span in
+// the statement's ctx, tagged with the statement's name, the rows an exec
+// affected and its error. Statements run without a span in their ctx, e.g. by the
+// outbox relay, are not traced.
+func SpanHook() QueryHook {
+	return func(ctx context.Context, q Query) (context.Context, func(QueryResult)) {
+		parent := opentracing.SpanFromContext(ctx)
+		if parent == nil {
+			return ctx, func(QueryResult) {}
+		}
+
+		span := parent.Tracer().StartSpan(q.Name, opentracing.ChildOf(parent.Context()))
+		ext.SpanKindRPCClient.Set(span)
+		ext.DBType.Set(span, "sql")
+		span.SetTag(statementNameTag, q.Name)
+		return opentracing.ContextWithSpan(ctx, span), func(r QueryResult) {
+			if r.RowsAffected >= 0 {
+				span.SetTag(rowsAffectedTag, r.RowsAffected)
+			}
+			if r.Err != nil {
+				ext.Error.Set(span, true)
+				span.LogFields(otlog.Error(r.Err))
+			}
+			span.Finish()
+		}
+	}
+}
+
+// SlowQueryHook logs each statement that takes longer than threshold as a
+// warning, with its args redacted to their types so no values are logged.
+// A threshold of 0 logs no statements.
+func SlowQueryHook(logger *logging.Logger, threshold time.Duration) QueryHook {
+	return slowQueryHook(threshold, func(q Query, d time.Duration, r QueryResult) {
+		fields := []logging.Field{
+			logging.String("statement", q.Name),
+			logging.Duration("duration", d),
+			logging.String("args", redactArgs(q.Args)),
+		}
+		if r.RowsAffected >= 0 {
+			fields = append(fields, logging.Int64("rowsAffected", r.RowsAffected))
+		}
+		if r.Err != nil {
+			fields = append(fields, logging.Error(r.Err))
+		}
+		logger.Warn("Slow query", fields...)
+	})
+}
+
+// slowQueryHook calls log with each statement that takes longer than threshold
+func slowQueryHook(threshold time.Duration, log func(q Query, d time.Duration, r QueryResult)) QueryHook {
+	return func(ctx context.Context, q Query) (context.Context, func(QueryResult)) {
+		if threshold <= 0 {
+			return ctx, func(QueryResult) {}
+		}
+
+		start := time.Now()
+		return ctx, func(r QueryResult) {
+			if d := time.Since(start); d > threshold {
+				log(q, d, r)
+			}
+		}
+	}
+}
+
+// redactArgs describes args by their types alone, e.g. "[string int64 <nil>]",
+// as they may hold names or other user data
+func redactArgs(args []interface{}) string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = fmt.Sprintf("%T", arg)
+	}
+	return "[" + strings.Join(types, " ") + "]"
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpanHook(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	stmt := map[string]string{
+		"delete-thunderbird":  "UPDATE thunderbirds",
+		"create-outbox-event": "INSERT outbox",
+	}
+
+	// ensures each statement gets a child span of the caller's, tagged with its
+	// name, rows affected and error
+	t.Run("Spans", func(t *testing.T) {
+		store, mock, err := NewTestDB(stmt)
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		store.AddQueryHook(SpanHook())
+
+		failed := errors.New("outbox unavailable")
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE thunderbirds").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WillReturnError(failed)
+		mock.ExpectRollback()
+
+		tracer := mocktracer.New()
+		parent := tracer.StartSpan("DeleteThunderbird")
+		ctx := opentracing.ContextWithSpan(context.Background(), parent)
+		err = store.Thunderbird.Delete(ctx, thunderbirdID)
+		assert.Error(t, err, "Expected the outbox error")
+
+		spans := tracer.FinishedSpans()
+		if ok := assert.Len(t, spans, 2, "Expected a span per statement"); !ok {
+			return
+		}
+		parentID := parent.Context().(mocktracer.MockSpanContext).SpanID
+
+		assert.Equal(t, "delete-thunderbird", spans[0].OperationName, "Expected the span to be named after the statement")
+		assert.Equal(t, parentID, spans[0].ParentID, "Expected a child of the caller's span")
+		assert.Equal(t, "delete-thunderbird", spans[0].Tag(statementNameTag), "Expected the statement name tag")
+		assert.Equal(t, int64(1), spans[0].Tag(rowsAffectedTag), "Expected the rows affected tag")
+		assert.Nil(t, spans[0].Tag("error"), "Expected no error tag")
+
+		assert.Equal(t, "create-outbox-event", spans[1].OperationName, "Expected the span to be named after the statement")
+		assert.Equal(t, true, spans[1].Tag("error"), "Expected the error tag")
+		assert.Nil(t, spans[1].Tag(rowsAffectedTag), "Expected no rows affected tag")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expected all expectations to be met")
+	})
+
+	// ensures statements run without a span in their ctx are not traced
+	t.Run("No parent", func(t *testing.T) {
+		_, done := SpanHook()(context.Background(), Query{Name: "get-thunderbird"})
+		done(QueryResult{RowsAffected: -1})
+	})
+}
+
+func TestSlowQueryHook(t *testing.T) {
+	// ensures only statements over the threshold are logged, with their args redacted
+	t.Run("Threshold", func(t *testing.T) {
+		logged := []string{}
+		log := func(q Query, d time.Duration, r QueryResult) {
+			logged = append(logged, q.Name+" "+redactArgs(q.Args))
+		}
+
+		_, done := slowQueryHook(time.Hour, log)(context.Background(), Query{Name: "get-thunderbird"})
+		done(QueryResult{RowsAffected: -1})
+		assert.Empty(t, logged, "Expected a fast statement not to be logged")
+
+		_, done = slowQueryHook(time.Nanosecond, log)(context.Background(), Query{
+			Name: "create-thunderbird",
+			Args: []interface{}{"72bc87f3-4a9f-4d05-93fe-844d3cd94c65", "Secret Name", int64(3), nil},
+		})
+		time.Sleep(time.Millisecond)
+		done(QueryResult{RowsAffected: 1})
+		assert.Equal(t, []string{"create-thunderbird [string string int64 <nil>]"}, logged, "Expected the slow statement with its arg types only")
+
+		_, done = slowQueryHook(0, log)(context.Background(), Query{Name: "get-thunderbird"})
+		time.Sleep(time.Millisecond)
+		done(QueryResult{RowsAffected: -1})
+		assert.Len(t, logged, 1, "Expected a threshold of 0 to log nothing")
+	})
+}
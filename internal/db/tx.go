@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	stderrors "errors"
+	"fmt"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/google/uuid"
+)
+
+// TxOptions configures a transaction started by WithTx
+type TxOptions struct {
+	// Isolation is the isolation level of the tx, the database default when zero
+	Isolation sql.IsolationLevel
+	// ReadOnly starts a read only tx
+	ReadOnly bool
+}
+
+// WithTx runs fn within a tx carried by the ctx passed to it, which the Tx suffixed
+// methods of the store's services join. The tx is committed, along with the outbox
+// events of its changes, if fn returns nil. It is rolled back if fn returns an
+// error or panics, and the panic is re-raised. When fn or the commit fails with a transient error,
+// such as a deadlock, the whole tx is run again under the store's RetryPolicy, so fn
+// must not have side effects outside of the tx.
+//
+// When ctx already carries a tx, from WithTx or BeginTx, fn runs within a SAVEPOINT
+// of it instead: an error rolls back only the writes and changes fn made, leaving
+// the outer tx to carry on, and opts are ignored as they can only be set when a tx
+// begins. A tx must not be used by concurrent calls.
+func (s *Store) WithTx(ctx context.Context, opts *TxOptions, fn func(ctx context.Context) error) error {
+	if state, stateErr := stateFromCtx(ctx); stateErr == nil {
+		return state.withSavepoint(ctx, fn)
+	}
+	return s.retry.do(ctx, withTxRetryName, func() error {
+		return s.runTx(ctx, opts, fn)
+	})
+}
+
+// runTx runs fn within a new tx, committing or rolling it back
+func (s *Store) runTx(ctx context.Context, opts *TxOptions, fn func(ctx context.Context) error) (err error) {
+	var txOpts *sql.TxOptions
+	if opts != nil {
+		txOpts = &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+	}
+	tx, err := s.db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	txCtx := ToCtx(ctx, tx)
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.RollbackTx(txCtx)
+			panic(p)
+		}
+	}()
+
+	if err = fn(txCtx); err != nil {
+		s.RollbackTx(txCtx)
+		return err
+	}
+	// a savepoint that failed to roll back left writes fn meant to discard
+	if state, _ := stateFromCtx(txCtx); state.rollbackErr() != nil {
+		s.RollbackTx(txCtx)
+		return state.rollbackErr()
+	}
+	if err = s.CommitTx(txCtx); err != nil {
+		return &commitError{err: err}
+	}
+	return nil
+}
+
+// rollbackErr returns the error of the first savepoint that failed to roll back
+func (state *txState) rollbackErr() error {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.savepointErr
+}
+
+// withSavepoint runs fn within a new savepoint of the tx, releasing it if fn
+// succeeds and otherwise rolling back to it along with the outbox events fn wrote.
+// If the rollback fails its error is returned joined with fn's, and the tx is
+// rolled back by the outer WithTx even if the error is handled.
+func (state *txState) withSavepoint(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	state.mu.Lock()
+	state.savepoints++
+	name := fmt.Sprintf("sp_%d", state.savepoints)
+	state.mu.Unlock()
+
+	if _, err = state.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return errors.WithStack(err)
+	}
+
+	rollback := func() error {
+		_, rbErr := state.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name)
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		if rbErr != nil {
+			rbErr = errors.Wrap(rbErr, "Error rolling back to savepoint - "+name)
+			if state.savepointErr == nil {
+				state.savepointErr = rbErr
+			}
+		}
+		return rbErr
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx); err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return stderrors.Join(err, rbErr)
+		}
+		return err
+	}
+	if _, err = state.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// WithTx runs fn within a tx carried by the ctx passed to it, committing it if fn
+// returns nil and rolling it back if fn returns an error or panics. Nested calls
+// stage their writes separately and discard only them on failure, like the
+// savepoints of Store.WithTx. opts are ignored.
+func (s *MemoryStore) WithTx(ctx context.Context, opts *TxOptions, fn func(ctx context.Context) error) (err error) {
+	if tx, txErr := memoryTxFromCtx(ctx); txErr == nil {
+		return s.withSavepoint(ctx, tx, fn)
+	}
+
+	txCtx, err := s.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			s.RollbackTx(txCtx)
+			panic(p)
+		}
+	}()
+
+	if err = fn(txCtx); err != nil {
+		s.RollbackTx(txCtx)
+		return err
+	}
+	if err = s.CommitTx(txCtx); err != nil {
+		return &commitError{err: err}
+	}
+	return nil
+}
+
+// withSavepoint snapshots the writes staged in tx and restores them if fn fails.
+// Staged rows are replaced rather than modified, so a shallow copy is enough.
+func (s *MemoryStore) withSavepoint(ctx context.Context, tx *memoryTx, fn func(ctx context.Context) error) (err error) {
+	s.mu.Lock()
+	rows := make(map[uuid.UUID]*Thunderbird, len(tx.rows))
+	for k, v := range tx.rows {
+		rows[k] = v
+	}
+	keys := make(map[string]memoryKey, len(tx.keys))
+	for k, v := range tx.keys {
+		keys[k] = v
+	}
+	mark := len(tx.changes)
+	s.mu.Unlock()
+
+	rollback := func() {
+		s.mu.Lock()
+		tx.rows, tx.keys, tx.changes = rows, keys, tx.changes[:mark]
+		s.mu.Unlock()
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
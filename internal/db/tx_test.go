@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_WithTx(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+	newStore := func(t *testing.T) (*Store, sqlmock.Sqlmock) {
+		store, mock, err := NewTestDB(map[string]string{
+			"delete-thunderbird":  "UPDATE thunderbirds",
+			"create-outbox-event": "INSERT outbox",
+		})
+		if ok := assert.NoError(t, err, "Expected no error"); !ok {
+			assert.FailNow(t, "test setup failed")
+		}
+		return store, mock
+	}
+	expectDelete := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("UPDATE thunderbirds").
+			WithArgs(thunderbirdID.String()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT outbox").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	// ensures the Tx methods join the tx, which is committed when fn succeeds
+	t.Run("Commit", func(t *testing.T) {
+		store, mock := newStore(t)
+		mock.ExpectBegin()
+		expectDelete(mock)
+		mock.ExpectCommit()
+
+		err := store.WithTx(ctx, &TxOptions{Isolation: sql.LevelReadCommitted}, func(ctx context.Context) error {
+			return store.Thunderbird.DeleteTx(ctx, thunderbirdID)
+		})
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures the tx is rolled back and the error returned when fn fails
+	t.Run("Rollback on error", func(t *testing.T) {
+		store, mock := newStore(t)
+		mock.ExpectBegin()
+		expectDelete(mock)
+		mock.ExpectRollback()
+
+		err := store.WithTx(ctx, nil, func(ctx context.Context) error {
+			if err := store.Thunderbird.DeleteTx(ctx, thunderbirdID); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		assert.ErrorIs(t, err, errFailed, "Expected the error from fn")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures the tx is rolled back and the panic re-raised when fn panics
+	t.Run("Rollback on panic", func(t *testing.T) {
+		store, mock := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		assert.PanicsWithValue(t, "boom", func() {
+			store.WithTx(ctx, nil, func(ctx context.Context) error {
+				panic("boom")
+			})
+		}, "Expected the panic to be re-raised")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures nested calls run in savepoints and only discard their own changes
+	t.Run("Savepoints", func(t *testing.T) {
+		store, mock := newStore(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectDelete(mock)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectDelete(mock)
+		mock.ExpectExec("RELEASE SAVEPOINT sp_2").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		err := store.WithTx(ctx, nil, func(ctx context.Context) error {
+			err := store.WithTx(ctx, nil, func(ctx context.Context) error {
+				if err := store.Thunderbird.DeleteTx(ctx, thunderbirdID); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			assert.ErrorIs(t, err, errFailed, "Expected the error from the nested fn")
+
+			return store.WithTx(ctx, nil, func(ctx context.Context) error {
+				return store.Thunderbird.DeleteTx(ctx, thunderbirdID)
+			})
+		})
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+
+	// ensures a savepoint that fails to roll back is reported and rolls back the
+	// outer tx even when the nested error is handled
+	t.Run("Savepoint rollback error", func(t *testing.T) {
+		store, mock := newStore(t)
+		errConn := errors.New("connection lost")
+		mock.ExpectBegin()
+		mock.ExpectExec("SAVEPOINT sp_1").WillReturnResult(sqlmock.NewResult(0, 0))
+		expectDelete(mock)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT sp_1").WillReturnError(errConn)
+		mock.ExpectRollback()
+
+		err := store.WithTx(ctx, nil, func(ctx context.Context) error {
+			err := store.WithTx(ctx, nil, func(ctx context.Context) error {
+				if err := store.Thunderbird.DeleteTx(ctx, thunderbirdID); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			assert.ErrorIs(t, err, errFailed, "Expected the error from the nested fn")
+			assert.ErrorIs(t, err, errConn, "Expected the rollback error")
+			return nil
+		})
+		assert.ErrorIs(t, err, errConn, "Expected the outer tx to fail with the rollback error")
+		assert.NoError(t, mock.ExpectationsWereMet(), "Expecting all mock conditions to be met")
+	})
+}
+
+func TestMemoryStore_WithTx(t *testing.T) {
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+
+	// ensures nested calls only discard their own writes and the outer tx commits
+	t.Run("Nested", func(t *testing.T) {
+		s := NewMemoryStore()
+		svc := s.Thunderbirds()
+		kept, discarded := uuid.New(), uuid.New()
+
+		err := s.WithTx(ctx, nil, func(ctx context.Context) error {
+			if err := svc.CreateTx(ctx, &Thunderbird{ID: kept, Name: "Foobar"}); err != nil {
+				return err
+			}
+			err := s.WithTx(ctx, nil, func(ctx context.Context) error {
+				if err := svc.CreateTx(ctx, &Thunderbird{ID: discarded, Name: "Bazqux"}); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			assert.ErrorIs(t, err, errFailed, "Expected the error from the nested fn")
+			return nil
+		})
+		assert.NoError(t, err, "Expected no error")
+
+		_, err = svc.Get(ctx, kept)
+		assert.NoError(t, err, "Expected the outer write to be committed")
+		_, err = svc.Get(ctx, discarded)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected the nested write to be discarded")
+	})
+
+	// ensures every write is discarded when fn fails
+	t.Run("Rollback", func(t *testing.T) {
+		s := NewMemoryStore()
+		svc := s.Thunderbirds()
+		ID := uuid.New()
+
+		err := s.WithTx(ctx, nil, func(ctx context.Context) error {
+			if err := svc.CreateTx(ctx, &Thunderbird{ID: ID, Name: "Foobar"}); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		assert.ErrorIs(t, err, errFailed, "Expected the error from fn")
+		_, err = svc.Get(ctx, ID)
+		assert.ErrorIs(t, err, ErrNotFound, "Expected the write to be discarded")
+	})
+}
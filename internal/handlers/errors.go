@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/caring/go-packages/pkg/errors"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+// internalErrorMsg is the message of an Internal status, which replaces the
+// error's own text as it may hold driver errors and SQL
+const internalErrorMsg = "internal error"
+
+// toStatus maps an error returned from the store to a gRPC status error. A done
+// request context maps to Canceled or DeadlineExceeded. Errors that map to no
+// other code are captured server side and returned to the client as a generic
+// Internal status.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, db.ErrNoRowsAffected):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, db.ErrUnknownField):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, db.ErrIdempotencyKeyReused):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, db.ErrConflict):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, db.ErrChangesExpired):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		sentry.CaptureException(err)
+		return status.Error(codes.Internal, internalErrorMsg)
+	}
+}
+
+// parseID parses a required id field, returning an InvalidArgument
+// status error if it is missing or malformed
+func parseID(field, value string) (uuid.UUID, error) {
+	ID, err := db.ParseUUID(value)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, field+" is not a valid UUID: "+value)
+	}
+	if ID == uuid.Nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, field+" is required")
+	}
+	return ID, nil
+}
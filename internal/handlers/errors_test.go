@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+func TestToStatus(t *testing.T) {
+	// ensures store errors map to their codes
+	t.Run("Known errors", func(t *testing.T) {
+		assert.Equal(t, codes.NotFound, status.Code(toStatus(db.ErrNotFound)), "Expected not found")
+		assert.Equal(t, codes.Aborted, status.Code(toStatus(db.ErrConflict)), "Expected aborted")
+	})
+
+	// ensures a request that was cancelled or timed out maps to its context code,
+	// including when wrapped by the store
+	t.Run("Context errors", func(t *testing.T) {
+		assert.Equal(t, codes.Canceled, status.Code(toStatus(fmt.Errorf("Error executing get thunderbird: %w", context.Canceled))), "Expected canceled")
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(toStatus(context.DeadlineExceeded)), "Expected deadline exceeded")
+	})
+
+	// ensures unexpected errors do not leak their driver or SQL text to clients
+	t.Run("Internal error", func(t *testing.T) {
+		err := toStatus(errors.New("Error executing get thunderbird - Error 1146: Table 'thunderbirds' doesn't exist"))
+		assert.Equal(t, codes.Internal, status.Code(err), "Expected internal")
+		assert.Equal(t, internalErrorMsg, status.Convert(err).Message(), "Expected a generic message")
+	})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+const (
+	// defaultPageSize is used when a list request does not set a page size
+	defaultPageSize = 50
+	// maxPageSize caps the page size of a list request
+	maxPageSize = 500
+)
+
+// pageToken is the decoded form of an opaque list page token. It carries the
+// keyset position of the last row returned along with the query it belongs to,
+// so a token cannot be replayed against a different filter or ordering.
+type pageToken struct {
+	OrderBy        pb.ThunderbirdOrderBy `json:"o"`
+	Descending     bool                  `json:"d,omitempty"`
+	NamePrefix     string                `json:"p,omitempty"`
+	IncludeDeleted bool                  `json:"x,omitempty"`
+	Name           string                `json:"n,omitempty"`
+	CreatedAt      time.Time             `json:"c,omitempty"`
+	ID             uuid.UUID             `json:"i"`
+}
+
+// newPageToken builds the token for the page following the given row
+func newPageToken(in *pb.ListThunderbirdsRequest, last *db.Thunderbird) *pageToken {
+	return &pageToken{
+		OrderBy:        in.GetOrderBy(),
+		Descending:     in.GetDescending(),
+		NamePrefix:     in.GetNamePrefix(),
+		IncludeDeleted: in.GetIncludeDeleted(),
+		Name:           last.Name,
+		CreatedAt:      last.CreatedAt,
+		ID:             last.ID,
+	}
+}
+
+// matches reports whether the token was issued for the same query as the request
+func (t *pageToken) matches(in *pb.ListThunderbirdsRequest) bool {
+	return t.OrderBy == in.GetOrderBy() &&
+		t.Descending == in.GetDescending() &&
+		t.NamePrefix == in.GetNamePrefix() &&
+		t.IncludeDeleted == in.GetIncludeDeleted()
+}
+
+// cursor returns the keyset position stored in the token
+func (t *pageToken) cursor() *db.ThunderbirdCursor {
+	return &db.ThunderbirdCursor{
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt,
+		ID:        t.ID,
+	}
+}
+
+// encode serializes the token into an opaque url safe string
+func (t *pageToken) encode() (string, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodePageToken parses a token produced by encode
+func decodePageToken(s string) (*pageToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	t := pageToken{}
+	if err = json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
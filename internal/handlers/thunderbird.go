@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+const (
+	// idempotencyTTL is how long a create request_id is remembered
+	idempotencyTTL = 24 * time.Hour
+	// maxRequestIDLength caps the length of a create request_id
+	maxRequestIDLength = 128
+	// maxNameLength is the size of the name column
+	maxNameLength = 64
+)
+
+// CreateThunderbird creates a new thunderbird with a server generated ID. When
+// request_id is set, a retry with the same request_id within idempotencyTTL
+// returns the thunderbird the first request created, as it is now and even if it
+// has since been deleted, instead of creating another. A retry with a different
+// request fails with FailedPrecondition.
+func CreateThunderbird(ctx context.Context, in *pb.CreateThunderbirdRequest, s db.ThunderbirdStore) (*pb.ThunderbirdResponse, error) {
+	if in.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if len(in.GetName()) > maxNameLength {
+		return nil, status.Errorf(codes.InvalidArgument, "name must be at most %d characters", maxNameLength)
+	}
+	if len(in.GetRequestId()) > maxRequestIDLength {
+		return nil, status.Errorf(codes.InvalidArgument, "request_id must be at most %d characters", maxRequestIDLength)
+	}
+
+	t, err := db.NewThunderbird(uuid.New().String(), in)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	created := true
+	if in.GetRequestId() != "" {
+		var hash []byte
+		if hash, err = db.CreateRequestHash(in); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		created, err = s.CreateIdempotent(ctx, t, in.GetRequestId(), hash, idempotencyTTL)
+	} else {
+		err = s.Create(ctx, t)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if !created {
+		// a replay is given the thunderbird as read with the idempotency key
+		return t.ToProto(), nil
+	}
+
+	// read back the row for the db generated columns, from the primary as the
+	// replica may not have the write yet
+	if t, err = s.Get(db.ReadYourWrites(ctx), t.ID); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return t.ToProto(), nil
+}
+
+// GetThunderbird fetches a single thunderbird by ID
+func GetThunderbird(ctx context.Context, in *pb.ByIDRequest, s db.ThunderbirdStore) (*pb.ThunderbirdResponse, error) {
+	ID, err := parseID("id", in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := s.Get(ctx, ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return t.ToProto(), nil
+}
+
+// UpdateThunderbird updates a thunderbird and returns its new state. Only the
+// fields in update_mask are written, or every updatable field when it is empty. A
+// non zero version makes the update conditional on the stored version, failing
+// with Aborted if another write got there first.
+func UpdateThunderbird(ctx context.Context, in *pb.UpdateThunderbirdRequest, s db.ThunderbirdStore) (*pb.ThunderbirdResponse, error) {
+	if _, err := parseID("id", in.GetId()); err != nil {
+		return nil, err
+	}
+
+	fields, err := updateFields(in.GetUpdateMask().GetPaths())
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if f != "name" {
+			continue
+		}
+		if in.GetName() == "" {
+			return nil, status.Error(codes.InvalidArgument, "name is required")
+		}
+		if len(in.GetName()) > maxNameLength {
+			return nil, status.Errorf(codes.InvalidArgument, "name must be at most %d characters", maxNameLength)
+		}
+	}
+
+	t, err := db.NewThunderbird(in.GetId(), in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	t.Version = in.GetVersion()
+
+	if err = s.UpdateFields(ctx, t, fields); err != nil {
+		return nil, toStatus(err)
+	}
+
+	if t, err = s.Get(db.ReadYourWrites(ctx), t.ID); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return t.ToProto(), nil
+}
+
+// updateFields validates the paths of an update mask against the updatable
+// fields, an empty mask selects every updatable field
+func updateFields(paths []string) ([]string, error) {
+	allowed := db.ThunderbirdFields()
+	if len(paths) == 0 {
+		return allowed, nil
+	}
+
+	for _, p := range paths {
+		ok := false
+		for _, f := range allowed {
+			if p == f {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "update_mask contains an unknown path: "+p)
+		}
+	}
+	return paths, nil
+}
+
+// DeleteThunderbird soft deletes a thunderbird and returns the deleted record,
+// read back within the same tx as the delete
+func DeleteThunderbird(ctx context.Context, in *pb.ByIDRequest, s db.Storage) (*pb.ThunderbirdResponse, error) {
+	ID, err := parseID("id", in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	var t *db.Thunderbird
+	err = s.WithTx(ctx, nil, func(ctx context.Context) (err error) {
+		if err = s.Thunderbirds().DeleteTx(ctx, ID); err != nil {
+			return err
+		}
+		t, err = s.Thunderbirds().GetDeletedTx(ctx, ID)
+		return err
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return t.ToProto(), nil
+}
+
+// UndeleteThunderbird restores a soft deleted thunderbird and returns the restored record
+func UndeleteThunderbird(ctx context.Context, in *pb.ByIDRequest, s db.ThunderbirdStore) (*pb.ThunderbirdResponse, error) {
+	ID, err := parseID("id", in.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.Restore(ctx, ID); err != nil {
+		return nil, toStatus(err)
+	}
+
+	t, err := s.Get(db.ReadYourWrites(ctx), ID)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return t.ToProto(), nil
+}
+
+// ListThunderbirds returns a page of thunderbirds and a token for the next page
+func ListThunderbirds(ctx context.Context, in *pb.ListThunderbirdsRequest, s db.ThunderbirdStore) (*pb.ListThunderbirdsResponse, error) {
+	params := &db.ListThunderbirdsParams{
+		Limit:          defaultPageSize,
+		NamePrefix:     in.GetNamePrefix(),
+		Descending:     in.GetDescending(),
+		IncludeDeleted: in.GetIncludeDeleted(),
+	}
+
+	switch in.GetOrderBy() {
+	case pb.ThunderbirdOrderBy_THUNDERBIRD_ORDER_BY_NAME:
+		params.OrderBy = db.OrderByName
+	case pb.ThunderbirdOrderBy_THUNDERBIRD_ORDER_BY_CREATED_AT:
+		params.OrderBy = db.OrderByCreatedAt
+	default:
+		return nil, status.Error(codes.InvalidArgument, "order_by is not supported: "+in.GetOrderBy().String())
+	}
+
+	switch size := in.GetPageSize(); {
+	case size < 0:
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	case size > maxPageSize:
+		params.Limit = maxPageSize
+	case size > 0:
+		params.Limit = int(size)
+	}
+
+	if in.GetPageToken() != "" {
+		token, err := decodePageToken(in.GetPageToken())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "page_token is malformed")
+		}
+		if !token.matches(in) {
+			return nil, status.Error(codes.InvalidArgument, "page_token does not match the request filters or ordering")
+		}
+		params.After = token.cursor()
+	}
+
+	// fetch one extra row to learn whether there is a next page
+	pageSize := params.Limit
+	params.Limit++
+
+	rows, err := s.List(ctx, params)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &pb.ListThunderbirdsResponse{}
+	if len(rows) > pageSize {
+		rows = rows[:pageSize]
+		if resp.NextPageToken, err = newPageToken(in, rows[pageSize-1]).encode(); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	resp.Thunderbirds = make([]*pb.ThunderbirdResponse, 0, len(rows))
+	for _, r := range rows {
+		resp.Thunderbirds = append(resp.Thunderbirds, r.ToProto())
+	}
+
+	return resp, nil
+}
+
+// maxLoadKeys caps the number of keys in a single load request
+const maxLoadKeys = 1000
+
+// LoadThunderbirds fetches a batch of thunderbirds by ID in one query. Results are
+// returned in key order, with keys that do not exist flagged as not found.
+func LoadThunderbirds(ctx context.Context, in *pb.LoadKeyRequest, s db.ThunderbirdStore) (*pb.LoadThunderbirdsResponse, error) {
+	keys := in.GetKeys()
+	if len(keys) > maxLoadKeys {
+		return nil, status.Errorf(codes.InvalidArgument, "keys must not contain more than %d entries", maxLoadKeys)
+	}
+
+	IDs := make([]uuid.UUID, 0, len(keys))
+	seen := map[uuid.UUID]bool{}
+	for _, k := range keys {
+		ID, err := parseID("keys", k)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[ID] {
+			seen[ID] = true
+			IDs = append(IDs, ID)
+		}
+	}
+
+	rows, err := s.Load(ctx, IDs)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	found := make(map[uuid.UUID]*pb.ThunderbirdResponse, len(rows))
+	for _, r := range rows {
+		found[r.ID] = r.ToProto()
+	}
+
+	resp := &pb.LoadThunderbirdsResponse{
+		Results: make([]*pb.ThunderbirdLoadResult, len(keys)),
+	}
+	for i, k := range keys {
+		// keys were validated above
+		ID, _ := db.ParseUUID(k)
+		t, ok := found[ID]
+		resp.Results[i] = &pb.ThunderbirdLoadResult{
+			Key:         k,
+			Thunderbird: t,
+			NotFound:    !ok,
+		}
+	}
+
+	return resp, nil
+}
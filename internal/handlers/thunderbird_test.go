@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+// newThunderbirdStore seeds an in memory db.ThunderbirdStore with rows
+func newThunderbirdStore(rows ...*db.Thunderbird) db.ThunderbirdStore {
+	s := db.NewMemoryStore()
+	s.Seed(rows...)
+	return s.Thunderbirds()
+}
+
+// deletedAt is a fixed soft delete time for seeding deleted rows
+var deletedAt = time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC)
+
+func TestCreateThunderbird(t *testing.T) {
+	// ensures a new thunderbird is stored under a generated ID
+	t.Run("Valid request", func(t *testing.T) {
+		s := newThunderbirdStore()
+
+		r, err := CreateThunderbird(context.Background(), &pb.CreateThunderbirdRequest{Name: "Foobar"}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Foobar", r.Name, "Expected name to be returned")
+
+		ID, err := uuid.Parse(r.Id)
+		assert.NoError(t, err, "Expected a valid UUID to be returned")
+		_, err = s.Get(context.Background(), ID)
+		assert.NoError(t, err, "Expected thunderbird to be stored")
+	})
+
+	// ensures a retried request_id returns the original thunderbird without creating another
+	t.Run("Replayed request ID", func(t *testing.T) {
+		s := newThunderbirdStore()
+		in := &pb.CreateThunderbirdRequest{Name: "Foobar", RequestId: "req-1"}
+
+		first, err := CreateThunderbird(context.Background(), in, s)
+		assert.NoError(t, err, "Expected no error")
+
+		second, err := CreateThunderbird(context.Background(), in, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, first.Id, second.Id, "Expected the original thunderbird to be returned")
+		all, err := s.List(context.Background(), &db.ListThunderbirdsParams{Limit: 10})
+		assert.NoError(t, err, "Expected no error")
+		assert.Len(t, all, 1, "Expected a single thunderbird to be stored")
+	})
+
+	// ensures a request_id retried after its thunderbird was deleted returns the
+	// deleted thunderbird
+	t.Run("Replayed after delete", func(t *testing.T) {
+		s := newThunderbirdStore()
+		in := &pb.CreateThunderbirdRequest{Name: "Foobar", RequestId: "req-1"}
+
+		first, err := CreateThunderbird(context.Background(), in, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.NoError(t, s.Delete(context.Background(), uuid.MustParse(first.Id)), "Expected no error")
+
+		second, err := CreateThunderbird(context.Background(), in, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, first.Id, second.Id, "Expected the original thunderbird to be returned")
+		assert.NotNil(t, second.DeletedAt, "Expected the thunderbird to be returned as deleted")
+	})
+
+	// ensures a request_id retried with a different request is rejected
+	t.Run("Reused request ID", func(t *testing.T) {
+		s := newThunderbirdStore()
+		_, err := CreateThunderbird(context.Background(), &pb.CreateThunderbirdRequest{Name: "Foobar", RequestId: "req-1"}, s)
+		assert.NoError(t, err, "Expected no error")
+
+		_, err = CreateThunderbird(context.Background(), &pb.CreateThunderbirdRequest{Name: "Bazqux", RequestId: "req-1"}, s)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err), "Expected failed precondition")
+	})
+
+	// ensures a missing name is rejected
+	t.Run("Missing name", func(t *testing.T) {
+		_, err := CreateThunderbird(context.Background(), &pb.CreateThunderbirdRequest{}, newThunderbirdStore())
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument")
+	})
+
+	// ensures a name longer than the name column is rejected
+	t.Run("Long name", func(t *testing.T) {
+		_, err := CreateThunderbird(context.Background(), &pb.CreateThunderbirdRequest{Name: strings.Repeat("a", maxNameLength+1)}, newThunderbirdStore())
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument")
+	})
+}
+
+func TestGetThunderbird(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	s := newThunderbirdStore(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar"})
+
+	// ensures an existing thunderbird is returned
+	t.Run("Existing record", func(t *testing.T) {
+		r, err := GetThunderbird(context.Background(), &pb.ByIDRequest{Id: thunderbirdID.String()}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, thunderbirdID.String(), r.Id, "Expected correct thunderbird ID to be returned")
+		assert.Equal(t, "Foobar", r.Name, "Expected correct name to be returned")
+	})
+
+	// ensures a missing record maps to NotFound
+	t.Run("Missing record", func(t *testing.T) {
+		_, err := GetThunderbird(context.Background(), &pb.ByIDRequest{Id: uuid.New().String()}, s)
+		assert.Equal(t, codes.NotFound, status.Code(err), "Expected not found")
+	})
+
+	// ensures malformed and empty IDs are rejected
+	t.Run("Invalid ID", func(t *testing.T) {
+		_, err := GetThunderbird(context.Background(), &pb.ByIDRequest{Id: "not-a-uuid"}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument")
+
+		_, err = GetThunderbird(context.Background(), &pb.ByIDRequest{}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument")
+	})
+}
+
+func TestUpdateThunderbird(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	// ensures the updated record is stored and returned
+	t.Run("Existing record", func(t *testing.T) {
+		s := newThunderbirdStore(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar"})
+
+		r, err := UpdateThunderbird(context.Background(), &pb.UpdateThunderbirdRequest{Id: thunderbirdID.String(), Name: "Bazqux"}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Bazqux", r.Name, "Expected new name to be returned")
+		stored, err := s.Get(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Bazqux", stored.Name, "Expected new name to be stored")
+	})
+
+	// ensures a matching version is accepted and bumped
+	t.Run("Current version", func(t *testing.T) {
+		s := newThunderbirdStore(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar", Version: 3})
+
+		r, err := UpdateThunderbird(context.Background(), &pb.UpdateThunderbirdRequest{Id: thunderbirdID.String(), Name: "Bazqux", Version: 3}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, int64(4), r.Version, "Expected the version to be bumped")
+	})
+
+	// ensures a stale version maps to Aborted
+	t.Run("Stale version", func(t *testing.T) {
+		s := newThunderbirdStore(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar", Version: 3})
+
+		_, err := UpdateThunderbird(context.Background(), &pb.UpdateThunderbirdRequest{Id: thunderbirdID.String(), Name: "Bazqux", Version: 2}, s)
+		assert.Equal(t, codes.Aborted, status.Code(err), "Expected aborted")
+		stored, err := s.Get(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Foobar", stored.Name, "Expected the stored name to be unchanged")
+	})
+
+	// ensures unknown mask paths are rejected and a masked name is still validated
+	t.Run("Update mask", func(t *testing.T) {
+		s := newThunderbirdStore(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar"})
+
+		_, err := UpdateThunderbird(context.Background(), &pb.UpdateThunderbirdRequest{
+			Id:         thunderbirdID.String(),
+			Name:       "Bazqux",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"created_at"}},
+		}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument for an unknown path")
+
+		r, err := UpdateThunderbird(context.Background(), &pb.UpdateThunderbirdRequest{
+			Id:         thunderbirdID.String(),
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument for a missing masked name")
+		assert.Nil(t, r, "Expected no response")
+
+		_, err = UpdateThunderbird(context.Background(), &pb.UpdateThunderbirdRequest{
+			Id:   thunderbirdID.String(),
+			Name: strings.Repeat("a", maxNameLength+1),
+		}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument for a long name")
+	})
+
+	// ensures no rows affected maps to FailedPrecondition
+	t.Run("Missing record", func(t *testing.T) {
+		s := newThunderbirdStore()
+
+		_, err := UpdateThunderbird(context.Background(), &pb.UpdateThunderbirdRequest{Id: thunderbirdID.String(), Name: "Bazqux"}, s)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err), "Expected failed precondition")
+	})
+}
+
+func TestDeleteThunderbird(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	// ensures the record is soft deleted and returned with its deletion time
+	t.Run("Existing record", func(t *testing.T) {
+		s := db.NewMemoryStore()
+		s.Seed(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar"})
+
+		r, err := DeleteThunderbird(context.Background(), &pb.ByIDRequest{Id: thunderbirdID.String()}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Foobar", r.Name, "Expected deleted record to be returned")
+		assert.NotNil(t, r.DeletedAt, "Expected the deletion time to be returned")
+		_, err = s.Thunderbirds().Get(context.Background(), thunderbirdID)
+		assert.ErrorIs(t, err, db.ErrNotFound, "Expected record to be soft deleted")
+	})
+
+	// ensures deleting twice maps to NotFound
+	t.Run("Already deleted", func(t *testing.T) {
+		s := db.NewMemoryStore()
+		s.Seed(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar", DeletedAt: &deletedAt})
+
+		_, err := DeleteThunderbird(context.Background(), &pb.ByIDRequest{Id: thunderbirdID.String()}, s)
+		assert.Equal(t, codes.NotFound, status.Code(err), "Expected not found")
+	})
+}
+
+func TestUndeleteThunderbird(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+
+	// ensures a soft deleted record is restored and returned
+	t.Run("Deleted record", func(t *testing.T) {
+		s := newThunderbirdStore(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar", DeletedAt: &deletedAt})
+
+		r, err := UndeleteThunderbird(context.Background(), &pb.ByIDRequest{Id: thunderbirdID.String()}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, "Foobar", r.Name, "Expected restored record to be returned")
+		_, err = s.Get(context.Background(), thunderbirdID)
+		assert.NoError(t, err, "Expected record to be restored")
+	})
+
+	// ensures restoring a record that is not deleted maps to NotFound
+	t.Run("Not deleted", func(t *testing.T) {
+		s := newThunderbirdStore(&db.Thunderbird{ID: thunderbirdID, Name: "Foobar"})
+
+		_, err := UndeleteThunderbird(context.Background(), &pb.ByIDRequest{Id: thunderbirdID.String()}, s)
+		assert.Equal(t, codes.NotFound, status.Code(err), "Expected not found")
+	})
+}
+
+func TestListThunderbirds(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := []*db.Thunderbird{}
+	for i, name := range []string{"alpha", "bravo", "charlie", "delta", "echo"} {
+		r := &db.Thunderbird{ID: uuid.New(), Name: name, CreatedAt: created.Add(time.Duration(i) * time.Hour)}
+		if name == "charlie" {
+			r.DeletedAt = &deletedAt
+		}
+		rows = append(rows, r)
+	}
+	s := newThunderbirdStore(rows...)
+
+	// names collects the names of a page in order
+	names := func(r *pb.ListThunderbirdsResponse) []string {
+		n := []string{}
+		for _, t := range r.Thunderbirds {
+			n = append(n, t.Name)
+		}
+		return n
+	}
+
+	// ensures pages follow on from each other until the listing is exhausted
+	t.Run("Paging through all records", func(t *testing.T) {
+		in := &pb.ListThunderbirdsRequest{PageSize: 2}
+
+		r, err := ListThunderbirds(context.Background(), in, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, []string{"alpha", "bravo"}, names(r), "Expected first page")
+		assert.NotEmpty(t, r.NextPageToken, "Expected a next page token")
+
+		in.PageToken = r.NextPageToken
+		r, err = ListThunderbirds(context.Background(), in, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, []string{"delta", "echo"}, names(r), "Expected deleted records to be skipped")
+		assert.Empty(t, r.NextPageToken, "Expected no next page token on the last page")
+	})
+
+	// ensures ordering, direction and deleted records are honored
+	t.Run("Descending by created at including deleted", func(t *testing.T) {
+		r, err := ListThunderbirds(context.Background(), &pb.ListThunderbirdsRequest{
+			OrderBy:        pb.ThunderbirdOrderBy_THUNDERBIRD_ORDER_BY_CREATED_AT,
+			Descending:     true,
+			IncludeDeleted: true,
+		}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, []string{"echo", "delta", "charlie", "bravo", "alpha"}, names(r), "Expected newest first")
+	})
+
+	// ensures the name prefix filters records
+	t.Run("Name prefix", func(t *testing.T) {
+		r, err := ListThunderbirds(context.Background(), &pb.ListThunderbirdsRequest{NamePrefix: "de"}, s)
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, []string{"delta"}, names(r), "Expected only matching names")
+	})
+
+	// ensures tokens cannot be replayed against a different query or forged
+	t.Run("Invalid page token", func(t *testing.T) {
+		r, err := ListThunderbirds(context.Background(), &pb.ListThunderbirdsRequest{PageSize: 1}, s)
+		assert.NoError(t, err, "Expected no error")
+
+		_, err = ListThunderbirds(context.Background(), &pb.ListThunderbirdsRequest{PageSize: 1, PageToken: r.NextPageToken, Descending: true}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument for a mismatched token")
+
+		_, err = ListThunderbirds(context.Background(), &pb.ListThunderbirdsRequest{PageToken: "not a token"}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument for a malformed token")
+	})
+
+	// ensures a negative page size is rejected
+	t.Run("Negative page size", func(t *testing.T) {
+		_, err := ListThunderbirds(context.Background(), &pb.ListThunderbirdsRequest{PageSize: -1}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument")
+	})
+}
+
+func TestLoadThunderbirds(t *testing.T) {
+	firstID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	secondID := uuid.MustParse("94cc5321-ec44-464f-9008-3d81f5e2c18f")
+	missingID := uuid.MustParse("0b4a6c3e-5d0b-4a57-9a1f-6f7e0c1d2e3f")
+	s := newThunderbirdStore(
+		&db.Thunderbird{ID: firstID, Name: "Foobar"},
+		&db.Thunderbird{ID: secondID, Name: "Bazqux"},
+	)
+
+	// ensures results follow key order, including duplicates and missing keys
+	t.Run("Mixed keys", func(t *testing.T) {
+		keys := []string{secondID.String(), missingID.String(), firstID.String(), secondID.String()}
+
+		r, err := LoadThunderbirds(context.Background(), &pb.LoadKeyRequest{Keys: keys}, s)
+		assert.NoError(t, err, "Expected no error")
+
+		if assert.Len(t, r.Results, 4, "Expected one result per key") {
+			for i, k := range keys {
+				assert.Equal(t, k, r.Results[i].Key, "Expected results in key order")
+			}
+			assert.Equal(t, "Bazqux", r.Results[0].Thunderbird.Name, "Expected found record")
+			assert.True(t, r.Results[1].NotFound, "Expected missing key to be flagged")
+			assert.Nil(t, r.Results[1].Thunderbird, "Expected no record for a missing key")
+			assert.Equal(t, "Foobar", r.Results[2].Thunderbird.Name, "Expected found record")
+			assert.Equal(t, "Bazqux", r.Results[3].Thunderbird.Name, "Expected duplicate keys to resolve")
+		}
+	})
+
+	// ensures a malformed key rejects the batch
+	t.Run("Invalid key", func(t *testing.T) {
+		_, err := LoadThunderbirds(context.Background(), &pb.LoadKeyRequest{Keys: []string{firstID.String(), "nope"}}, s)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "Expected invalid argument")
+	})
+}
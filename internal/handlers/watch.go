@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+// eventTypes maps db change types to their proto event type
+var eventTypes = map[db.ChangeType]pb.ThunderbirdEventType{
+	db.ChangeCreated:  pb.ThunderbirdEventType_THUNDERBIRD_EVENT_TYPE_CREATED,
+	db.ChangeUpdated:  pb.ThunderbirdEventType_THUNDERBIRD_EVENT_TYPE_UPDATED,
+	db.ChangeDeleted:  pb.ThunderbirdEventType_THUNDERBIRD_EVENT_TYPE_DELETED,
+	db.ChangeRestored: pb.ThunderbirdEventType_THUNDERBIRD_EVENT_TYPE_RESTORED,
+	db.ChangePurged:   pb.ThunderbirdEventType_THUNDERBIRD_EVENT_TYPE_PURGED,
+}
+
+// WatchThunderbirds streams thunderbird changes until the client disconnects. A
+// resume token replays the changes made after it, on any instance of the service;
+// a token the store can no longer serve fails with OutOfRange, and the client
+// should re-read state and watch anew.
+func WatchThunderbirds(in *pb.WatchThunderbirdsRequest, stream pb.FordThunderbirdService_WatchThunderbirdsServer, s db.Storage) error {
+	ctx := stream.Context()
+	sub, err := s.Subscribe(ctx, in.GetResumeToken())
+	if err != nil {
+		return toStatus(err)
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case c, ok := <-sub.Changes():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					return toStatus(err)
+				}
+				return nil
+			}
+
+			err := stream.Send(&pb.ThunderbirdEvent{
+				Type:        eventTypes[c.Type],
+				Thunderbird: c.Thunderbird.ToProto(),
+				OccurredAt:  timestamppb.New(c.At),
+				ResumeToken: c.Token,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
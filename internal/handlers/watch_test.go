@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+	"github.com/caring/ford-thunderbird/pb"
+)
+
+// fakeWatchStream collects sent events and cancels its context once it has
+// received want of them
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	cancel context.CancelFunc
+	want   int
+	sent   []*pb.ThunderbirdEvent
+}
+
+func newFakeWatchStream(want int) *fakeWatchStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &fakeWatchStream{ctx: ctx, cancel: cancel, want: want}
+}
+
+func (f *fakeWatchStream) Context() context.Context {
+	return f.ctx
+}
+
+func (f *fakeWatchStream) Send(e *pb.ThunderbirdEvent) error {
+	f.sent = append(f.sent, e)
+	if len(f.sent) >= f.want {
+		f.cancel()
+	}
+	return nil
+}
+
+func TestWatchThunderbirds(t *testing.T) {
+	thunderbirdID := uuid.MustParse("72bc87f3-4a9f-4d05-93fe-844d3cd94c65")
+	s := db.NewMemoryStore()
+
+	sub, err := s.Subscribe(context.Background(), "")
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	defer sub.Close()
+
+	err = s.Thunderbirds().Create(context.Background(), &db.Thunderbird{ID: thunderbirdID, Name: "Foobar"})
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	err = s.Thunderbirds().Delete(context.Background(), thunderbirdID)
+	if ok := assert.NoError(t, err, "Expected no error"); !ok {
+		assert.FailNow(t, "test setup failed")
+	}
+	created := <-sub.Changes()
+	deleted := <-sub.Changes()
+
+	// ensures a resumed watch replays the changes after the token as events
+	t.Run("Resuming", func(t *testing.T) {
+		stream := newFakeWatchStream(1)
+
+		err := WatchThunderbirds(&pb.WatchThunderbirdsRequest{ResumeToken: created.Token}, stream, s)
+		assert.NoError(t, err, "Expected no error")
+
+		if assert.Len(t, stream.sent, 1, "Expected the delete event") {
+			assert.Equal(t, pb.ThunderbirdEventType_THUNDERBIRD_EVENT_TYPE_DELETED, stream.sent[0].Type, "Expected a delete event")
+			assert.Equal(t, thunderbirdID.String(), stream.sent[0].Thunderbird.Id, "Expected the deleted ID")
+			assert.Equal(t, deleted.Token, stream.sent[0].ResumeToken, "Expected the change token")
+		}
+	})
+
+	// ensures an unusable token maps to OutOfRange
+	t.Run("Expired token", func(t *testing.T) {
+		err := WatchThunderbirds(&pb.WatchThunderbirdsRequest{ResumeToken: "garbage"}, newFakeWatchStream(1), s)
+		assert.Equal(t, codes.OutOfRange, status.Code(err), "Expected out of range")
+	})
+}
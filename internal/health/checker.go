@@ -0,0 +1,154 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	// errNotChecked is reported until the first check completes
+	errNotChecked = errors.New("health has not been checked yet")
+	// errShutdown is reported once the server has started shutting down
+	errShutdown = errors.New("the server is shutting down")
+)
+
+// Pinger is the part of db.Storage the checker depends on
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Checker periodically pings the store and reports the result through the
+// standard grpc health service and the HTTP readiness endpoint. The overall
+// server status, named "", and each registered service follow the same check.
+type Checker struct {
+	pinger   Pinger
+	logger   *logging.Logger
+	server   *health.Server
+	services []string
+	interval time.Duration
+	timeout  time.Duration
+
+	mu  sync.RWMutex
+	err error
+}
+
+// NewChecker creates a checker that pings every interval, failing a ping that
+// takes longer than timeout. Every service starts out NOT_SERVING until the
+// first check passes.
+func NewChecker(pinger Pinger, logger *logging.Logger, interval, timeout time.Duration, services ...string) *Checker {
+	c := &Checker{
+		pinger:   pinger,
+		logger:   logger,
+		server:   health.NewServer(),
+		services: append([]string{""}, services...),
+		interval: interval,
+		timeout:  timeout,
+		err:      errNotChecked,
+	}
+	c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return c
+}
+
+// Server returns the grpc health service to register on a grpc.Server
+func (c *Checker) Server() healthpb.HealthServer {
+	return c.server
+}
+
+// Run checks health until ctx is done, then reports every service as
+// NOT_SERVING for good so clients stop routing to a server that is stopping
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			c.Shutdown()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown reports every service as NOT_SERVING and readiness as failed, and
+// ignores any later check
+func (c *Checker) Shutdown() {
+	c.mu.Lock()
+	c.err = errShutdown
+	c.mu.Unlock()
+	c.server.Shutdown()
+}
+
+// Check pings the store once, updates the status of every service and returns
+// the ping's error
+func (c *Checker) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	err := c.pinger.Ping(ctx)
+
+	c.mu.Lock()
+	prev := c.err
+	if prev == errShutdown {
+		c.mu.Unlock()
+		return err
+	}
+	c.err = err
+	c.mu.Unlock()
+
+	if err != nil {
+		c.setStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+		if prev == nil || prev == errNotChecked {
+			c.logger.Error("Health check failed, not serving:" + err.Error())
+		}
+		return err
+	}
+
+	c.setStatus(healthpb.HealthCheckResponse_SERVING)
+	if prev != nil {
+		c.logger.Info("Health check passed, serving")
+	}
+	return nil
+}
+
+// Err returns the error of the latest check, nil if it passed
+func (c *Checker) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.err
+}
+
+// setStatus sets the status of every service
+func (c *Checker) setStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, s := range c.services {
+		c.server.SetServingStatus(s, status)
+	}
+}
+
+// LiveHandler reports that the process is up and serving HTTP. It does not
+// depend on the store, so a database outage does not get the task restarted.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// ReadyHandler reports whether the latest check passed, with 503 Service
+// Unavailable when it did not so load balancers stop routing to the task
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Err() != nil {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
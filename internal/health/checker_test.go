@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakePinger fails every ping with err
+type fakePinger struct {
+	err error
+}
+
+func (f *fakePinger) Ping(ctx context.Context) error {
+	return f.err
+}
+
+func TestChecker(t *testing.T) {
+	ctx := context.Background()
+	const svc = "ford_thunderbird.FordThunderbirdService"
+
+	// status returns the grpc status of a service
+	status := func(c *Checker, service string) healthpb.HealthCheckResponse_ServingStatus {
+		r, err := c.Server().Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		assert.NoError(t, err, "Expected no error")
+		return r.GetStatus()
+	}
+
+	// ready returns the status code of the readiness endpoint
+	ready := func(c *Checker) int {
+		w := httptest.NewRecorder()
+		c.ReadyHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		return w.Code
+	}
+
+	// ensures nothing is served before the first check
+	t.Run("Unchecked", func(t *testing.T) {
+		c := NewChecker(&fakePinger{}, &logging.Logger{}, time.Second, time.Second, svc)
+
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(c, ""), "Expected the server not to be serving")
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(c, svc), "Expected the service not to be serving")
+		assert.Equal(t, http.StatusServiceUnavailable, ready(c), "Expected not ready")
+	})
+
+	// ensures every service follows the store's availability
+	t.Run("Store availability", func(t *testing.T) {
+		p := &fakePinger{}
+		c := NewChecker(p, &logging.Logger{}, time.Second, time.Second, svc)
+
+		assert.NoError(t, c.Check(ctx), "Expected no error")
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(c, ""), "Expected the server to be serving")
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status(c, svc), "Expected the service to be serving")
+		assert.Equal(t, http.StatusOK, ready(c), "Expected ready")
+
+		p.err = errors.New("connection refused")
+		assert.Error(t, c.Check(ctx), "Expected the ping error")
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(c, svc), "Expected the service not to be serving")
+		assert.Equal(t, http.StatusServiceUnavailable, ready(c), "Expected not ready")
+	})
+
+	// ensures a stopped checker stops serving for good
+	t.Run("Stopped", func(t *testing.T) {
+		c := NewChecker(&fakePinger{}, &logging.Logger{}, time.Hour, time.Second, svc)
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			c.Run(ctx)
+			close(done)
+		}()
+
+		cancel()
+		<-done
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(c, svc), "Expected the service not to be serving")
+
+		c.Check(context.Background())
+		assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(c, svc), "Expected shutdown to be permanent")
+		assert.Equal(t, http.StatusServiceUnavailable, ready(c), "Expected not ready")
+	})
+
+	// ensures liveness does not depend on the store
+	t.Run("Liveness", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		LiveHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "Expected live")
+	})
+}
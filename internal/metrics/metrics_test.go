@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+func TestMetrics(t *testing.T) {
+	// ensures unary RPCs are counted by service, method and code
+	t.Run("Unary RPCs", func(t *testing.T) {
+		m := New()
+		intercept := m.UnaryServerInterceptor()
+		info := &grpc.UnaryServerInfo{FullMethod: "/ford_thunderbird.FordThunderbirdService/GetThunderbird"}
+
+		ok := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+		missing := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "missing")
+		}
+		_, err := intercept(context.Background(), nil, info, ok)
+		assert.NoError(t, err, "Expected no error")
+		_, err = intercept(context.Background(), nil, info, missing)
+		assert.Equal(t, codes.NotFound, status.Code(err), "Expected the handler's error")
+
+		labels := []string{"unary", "ford_thunderbird.FordThunderbirdService", "GetThunderbird"}
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.rpcHandled.WithLabelValues(append(labels, "OK")...)), "Expected one OK")
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.rpcHandled.WithLabelValues(append(labels, "NotFound")...)), "Expected one NotFound")
+		assert.Equal(t, 1, testutil.CollectAndCount(m.rpcDuration), "Expected one latency series")
+	})
+
+	// ensures streaming RPCs are labeled by their type
+	t.Run("Streaming RPCs", func(t *testing.T) {
+		m := New()
+		info := &grpc.StreamServerInfo{FullMethod: "/ford_thunderbird.FordThunderbirdService/WatchThunderbirds", IsServerStream: true}
+
+		err := m.StreamServerInterceptor()(nil, nil, info, func(srv interface{}, ss grpc.ServerStream) error { return nil })
+		assert.NoError(t, err, "Expected no error")
+
+		c := m.rpcHandled.WithLabelValues("server_stream", "ford_thunderbird.FordThunderbirdService", "WatchThunderbirds", "OK")
+		assert.Equal(t, 1.0, testutil.ToFloat64(c), "Expected one OK")
+	})
+
+	// ensures statements are timed by name and errors counted
+	t.Run("Queries", func(t *testing.T) {
+		m := New()
+		hook := m.QueryHook()
+
+		_, done := hook(context.Background(), db.Query{Name: "get-thunderbird"})
+		done(db.QueryResult{RowsAffected: -1})
+		_, done = hook(context.Background(), db.Query{Name: "get-thunderbird"})
+		done(db.QueryResult{Err: errors.New("bad connection"), RowsAffected: -1})
+
+		assert.Equal(t, 1, testutil.CollectAndCount(m.queries), "Expected one series per statement")
+		assert.Equal(t, 1.0, testutil.ToFloat64(m.queryErrors.WithLabelValues("get-thunderbird")), "Expected one error")
+	})
+
+	// ensures retries are counted by name
+	t.Run("Retries", func(t *testing.T) {
+		m := New()
+		hook := m.RetryHook()
+		hook("with-tx", 2, errors.New("deadlock"))
+		hook("with-tx", 3, errors.New("deadlock"))
+
+		assert.Equal(t, 2.0, testutil.ToFloat64(m.retries.WithLabelValues("with-tx")), "Expected two retries")
+	})
+
+	// ensures the pool statistics and every metric are served for scraping
+	t.Run("Handler", func(t *testing.T) {
+		m := New()
+		m.RegisterDBStats(func() sql.DBStats { return sql.DBStats{OpenConnections: 3, InUse: 1} })
+		_, done := m.QueryHook()(context.Background(), db.Query{Name: "get-thunderbird"})
+		done(db.QueryResult{RowsAffected: -1})
+
+		w := httptest.NewRecorder()
+		m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, w.Code, "Expected OK")
+
+		body := w.Body.String()
+		for _, want := range []string{
+			"db_open_connections 3",
+			"db_in_use_connections 1",
+			`db_query_duration_seconds_count{statement="get-thunderbird"} 1`,
+			"go_goroutines",
+		} {
+			assert.True(t, strings.Contains(body, want), "Expected the metrics to include "+want)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	"github.com/aws/aws-sdk-go-v2/service/firehose/types"
+	"github.com/caring/go-packages/pkg/errors"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+// maxFirehoseBatch is the most records PutRecordBatch accepts in one call
+const maxFirehoseBatch = 500
+
+// FirehoseAPI is the subset of the Firehose client used by FirehosePublisher
+type FirehoseAPI interface {
+	PutRecordBatch(ctx context.Context, params *firehose.PutRecordBatchInput, optFns ...func(*firehose.Options)) (*firehose.PutRecordBatchOutput, error)
+}
+
+// FirehosePublisher publishes records as newline delimited JSON to a Kinesis
+// Firehose delivery stream
+type FirehosePublisher struct {
+	client FirehoseAPI
+	stream string
+}
+
+var _ Publisher = &FirehosePublisher{}
+
+// NewFirehosePublisher creates a publisher for the named delivery stream
+func NewFirehosePublisher(client FirehoseAPI, stream string) *FirehosePublisher {
+	return &FirehosePublisher{
+		client: client,
+		stream: stream,
+	}
+}
+
+// Publish sends the events in batches of up to 500 records. Firehose can accept
+// part of a batch, in which case an error is returned so the outbox retries the
+// whole batch.
+func (p *FirehosePublisher) Publish(ctx context.Context, events []*db.OutboxEvent) error {
+	records := make([]types.Record, 0, len(events))
+	for _, e := range events {
+		b, err := json.Marshal(NewRecord(e))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		records = append(records, types.Record{Data: append(b, '\n')})
+	}
+
+	for len(records) > 0 {
+		n := len(records)
+		if n > maxFirehoseBatch {
+			n = maxFirehoseBatch
+		}
+
+		out, err := p.client.PutRecordBatch(ctx, &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(p.stream),
+			Records:            records[:n],
+		})
+		if err != nil {
+			return errors.Wrap(err, "Error publishing outbox events to firehose - "+p.stream)
+		}
+		if failed := aws.ToInt32(out.FailedPutCount); failed > 0 {
+			return errors.New(fmt.Sprintf("Error publishing outbox events to firehose - %s: %d of %d records failed", p.stream, failed, n))
+		}
+
+		records = records[n:]
+	}
+
+	return nil
+}
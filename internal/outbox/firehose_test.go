@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFirehose records PutRecordBatch calls and reports failed as the failed put count
+type fakeFirehose struct {
+	calls  []*firehose.PutRecordBatchInput
+	failed int32
+}
+
+func (f *fakeFirehose) PutRecordBatch(ctx context.Context, params *firehose.PutRecordBatchInput, optFns ...func(*firehose.Options)) (*firehose.PutRecordBatchOutput, error) {
+	f.calls = append(f.calls, params)
+	return &firehose.PutRecordBatchOutput{FailedPutCount: aws.Int32(f.failed)}, nil
+}
+
+func TestFirehosePublisher_Publish(t *testing.T) {
+	// ensures events are split into batches of newline delimited JSON records
+	t.Run("Batching", func(t *testing.T) {
+		client := &fakeFirehose{}
+		p := NewFirehosePublisher(client, "reporting")
+
+		err := p.Publish(context.Background(), newEvents(maxFirehoseBatch+1))
+		assert.NoError(t, err, "Expected no error")
+
+		if assert.Len(t, client.calls, 2, "Expected two batches") {
+			assert.Equal(t, "reporting", aws.ToString(client.calls[0].DeliveryStreamName), "Expected the configured stream")
+			assert.Len(t, client.calls[0].Records, maxFirehoseBatch, "Expected a full first batch")
+			assert.Len(t, client.calls[1].Records, 1, "Expected the remainder in the second batch")
+
+			data := client.calls[0].Records[0].Data
+			assert.Equal(t, byte('\n'), data[len(data)-1], "Expected records to be newline terminated")
+
+			r := Record{}
+			assert.NoError(t, json.Unmarshal(data, &r), "Expected records to be JSON")
+			assert.Equal(t, int64(1), r.EventID, "Expected the event ID in the record")
+		}
+	})
+
+	// ensures a partially accepted batch is reported as a failure
+	t.Run("Partial failure", func(t *testing.T) {
+		client := &fakeFirehose{failed: 1}
+		p := NewFirehosePublisher(client, "reporting")
+
+		err := p.Publish(context.Background(), newEvents(3))
+		assert.Error(t, err, "Expected an error when records fail")
+	})
+}
@@ -0,0 +1,46 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+// MemoryPublisher is a Publisher that keeps records in memory, for tests and
+// local development
+type MemoryPublisher struct {
+	mu      sync.Mutex
+	records []Record
+	// Err is returned from Publish instead of storing records when set
+	Err error
+}
+
+var _ Publisher = &MemoryPublisher{}
+
+// NewMemoryPublisher creates an empty MemoryPublisher
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+// Publish stores a record for each event
+func (p *MemoryPublisher) Publish(ctx context.Context, events []*db.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Err != nil {
+		return p.Err
+	}
+	for _, e := range events {
+		p.records = append(p.records, NewRecord(e))
+	}
+	return nil
+}
+
+// Records returns a copy of every record published so far
+func (p *MemoryPublisher) Records() []Record {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Record{}, p.records...)
+}
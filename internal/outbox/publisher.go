@@ -0,0 +1,52 @@
+// Package outbox relays the mutation events recorded in the db outbox table to
+// downstream sinks such as the analytics Firehose.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+// Publisher delivers a batch of outbox events to a sink. A batch is either
+// delivered in full or an error is returned and the whole batch is retried, so
+// sinks may see an event more than once and must dedupe on Record.EventID.
+type Publisher interface {
+	Publish(ctx context.Context, events []*db.OutboxEvent) error
+}
+
+// DiscardPublisher is a Publisher that drops every event. A relay runs with it
+// when no sink is configured, so events are still marked published and purged
+// once the change feed no longer needs them.
+type DiscardPublisher struct{}
+
+var _ Publisher = DiscardPublisher{}
+
+// Publish drops events
+func (DiscardPublisher) Publish(ctx context.Context, events []*db.OutboxEvent) error {
+	return nil
+}
+
+// Record is the document published for each outbox event
+type Record struct {
+	EventID       int64           `json:"event_id"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+// NewRecord builds the published document for an outbox event
+func NewRecord(e *db.OutboxEvent) Record {
+	return Record{
+		EventID:       e.ID,
+		AggregateType: e.AggregateType,
+		AggregateID:   e.AggregateID.String(),
+		EventType:     e.Type,
+		Payload:       e.Payload,
+		OccurredAt:    e.CreatedAt,
+	}
+}
@@ -0,0 +1,108 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/getsentry/sentry-go"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+// Relay periodically moves events from the outbox to a Publisher. Events are
+// claimed before they are published and marked published only after, so delivery
+// is at least once and consumers must dedupe on Record.EventID. Published events
+// are kept for the change feed until their retention passes, and purged on a
+// schedule of their own.
+type Relay struct {
+	store         db.OutboxStore
+	publisher     Publisher
+	logger        *logging.Logger
+	interval      time.Duration
+	purgeInterval time.Duration
+	batchSize     int
+}
+
+// NewRelay creates a relay that polls the outbox every interval and publishes
+// up to batchSize events per tx, and purges published events every purgeInterval
+func NewRelay(store db.OutboxStore, publisher Publisher, logger *logging.Logger, interval, purgeInterval time.Duration, batchSize int) *Relay {
+	return &Relay{
+		store:         store,
+		publisher:     publisher,
+		logger:        logger,
+		interval:      interval,
+		purgeInterval: purgeInterval,
+		batchSize:     batchSize,
+	}
+}
+
+// Run relays events, and purges those published past their retention, until ctx
+// is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	purgeTicker := time.NewTicker(r.purgeInterval)
+	defer purgeTicker.Stop()
+
+	r.flush(ctx)
+	r.purge(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.flush(ctx)
+		case <-purgeTicker.C:
+			r.purge(ctx)
+		}
+	}
+}
+
+// flush runs Flush, reporting its error
+func (r *Relay) flush(ctx context.Context) {
+	if _, err := r.Flush(ctx); err != nil && ctx.Err() == nil {
+		sentry.CaptureException(err)
+		r.logger.Error("Error relaying outbox events:" + err.Error())
+	}
+}
+
+// purge runs Purge, reporting its error
+func (r *Relay) purge(ctx context.Context) {
+	if _, err := r.Purge(ctx); err != nil && ctx.Err() == nil {
+		sentry.CaptureException(err)
+		r.logger.Error("Error purging published outbox events:" + err.Error())
+	}
+}
+
+// Flush relays batches until the outbox is drained or an error occurs, and
+// returns the number of events published
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := r.store.Relay(ctx, r.batchSize, r.publisher.Publish)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < r.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// Purge removes batches of events published past their retention until none
+// remain or an error occurs, and returns the number of events removed
+func (r *Relay) Purge(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := r.store.Purge(ctx, r.batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < r.batchSize {
+			return total, nil
+		}
+	}
+}
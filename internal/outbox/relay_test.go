@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/caring/ford-thunderbird/internal/db"
+)
+
+// fakeOutboxStore is a slice backed db.OutboxStore, every published event is
+// past its retention
+type fakeOutboxStore struct {
+	pending   []*db.OutboxEvent
+	published []*db.OutboxEvent
+}
+
+func (f *fakeOutboxStore) Relay(ctx context.Context, limit int, publish func(context.Context, []*db.OutboxEvent) error) (int, error) {
+	n := len(f.pending)
+	if n > limit {
+		n = limit
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := publish(ctx, f.pending[:n]); err != nil {
+		return 0, err
+	}
+	f.published = append(f.published, f.pending[:n]...)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+func (f *fakeOutboxStore) Purge(ctx context.Context, limit int) (int, error) {
+	n := len(f.published)
+	if n > limit {
+		n = limit
+	}
+	f.published = f.published[n:]
+	return n, nil
+}
+
+// newEvents creates n thunderbird created events with sequential IDs
+func newEvents(n int) []*db.OutboxEvent {
+	events := []*db.OutboxEvent{}
+	for i := 1; i <= n; i++ {
+		events = append(events, &db.OutboxEvent{
+			ID:            int64(i),
+			AggregateType: db.ThunderbirdAggregate,
+			AggregateID:   uuid.New(),
+			Type:          db.ThunderbirdCreatedType,
+			Payload:       []byte(`{}`),
+		})
+	}
+	return events
+}
+
+func TestRelay_Flush(t *testing.T) {
+	// ensures every pending event is published across batches, in order
+	t.Run("Draining the outbox", func(t *testing.T) {
+		store := &fakeOutboxStore{pending: newEvents(5)}
+		publisher := NewMemoryPublisher()
+		r := NewRelay(store, publisher, &logging.Logger{}, 0, 0, 2)
+
+		n, err := r.Flush(context.Background())
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 5, n, "Expected every event to be relayed")
+		assert.Empty(t, store.pending, "Expected the outbox to be drained")
+
+		records := publisher.Records()
+		if assert.Len(t, records, 5, "Expected every event to be published") {
+			for i, r := range records {
+				assert.Equal(t, int64(i+1), r.EventID, "Expected events in order")
+			}
+		}
+	})
+
+	// ensures a failed publish leaves events pending
+	t.Run("Failed publish", func(t *testing.T) {
+		store := &fakeOutboxStore{pending: newEvents(3)}
+		publisher := NewMemoryPublisher()
+		publisher.Err = errors.New("sink unavailable")
+		r := NewRelay(store, publisher, &logging.Logger{}, 0, 0, 2)
+
+		n, err := r.Flush(context.Background())
+		assert.Error(t, err, "Expected the publish error")
+		assert.Equal(t, 0, n, "Expected no events to be relayed")
+		assert.Len(t, store.pending, 3, "Expected events to stay pending")
+	})
+}
+
+func TestRelay_Purge(t *testing.T) {
+	// ensures every published event is purged across batches, leaving the pending
+	t.Run("Purges in batches", func(t *testing.T) {
+		store := &fakeOutboxStore{published: newEvents(5), pending: newEvents(1)}
+		r := NewRelay(store, NewMemoryPublisher(), &logging.Logger{}, 0, 0, 2)
+
+		n, err := r.Purge(context.Background())
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 5, n, "Expected every published event to be purged")
+		assert.Empty(t, store.published, "Expected no published events to remain")
+		assert.Len(t, store.pending, 1, "Expected pending events to be kept")
+	})
+}
+
+func TestRelay_Run(t *testing.T) {
+	// ensures a relay without a sink still marks events published and purges them
+	t.Run("Discarding events", func(t *testing.T) {
+		store := &fakeOutboxStore{pending: newEvents(3)}
+		r := NewRelay(store, DiscardPublisher{}, &logging.Logger{}, time.Hour, time.Hour, 2)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r.Run(ctx)
+
+		assert.Empty(t, store.pending, "Expected every event to be marked published")
+		assert.Empty(t, store.published, "Expected the published events to be purged")
+	})
+}
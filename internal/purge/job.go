@@ -0,0 +1,101 @@
+package purge
+
+import (
+	"context"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/getsentry/sentry-go"
+)
+
+// Store is the part of db.ThunderbirdStore the job depends on
+type Store interface {
+	Purge(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+	PurgeIdempotencyKeys(ctx context.Context, limit int) (int, error)
+}
+
+// Job periodically hard deletes thunderbirds that have been soft deleted for
+// longer than the retention window, along with expired idempotency keys
+type Job struct {
+	store     Store
+	logger    *logging.Logger
+	retention time.Duration
+	interval  time.Duration
+	batchSize int
+	now       func() time.Time
+}
+
+// NewJob creates a job that runs every interval and removes up to batchSize
+// rows per tx
+func NewJob(store Store, logger *logging.Logger, retention, interval time.Duration, batchSize int) *Job {
+	return &Job{
+		store:     store,
+		logger:    logger,
+		retention: retention,
+		interval:  interval,
+		batchSize: batchSize,
+		now:       time.Now,
+	}
+}
+
+// Run purges expired rows until ctx is done
+func (j *Job) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := j.Purge(ctx)
+		if err != nil && ctx.Err() == nil {
+			sentry.CaptureException(err)
+			j.logger.Error("Error purging deleted thunderbirds:" + err.Error())
+		}
+		if n > 0 {
+			j.logger.Info("Purged deleted thunderbirds", logging.Int("count", n))
+		}
+
+		if _, err = j.PurgeIdempotencyKeys(ctx); err != nil && ctx.Err() == nil {
+			sentry.CaptureException(err)
+			j.logger.Error("Error purging expired idempotency keys:" + err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge removes batches until no expired rows remain or an error occurs, and
+// returns the number of rows removed
+func (j *Job) Purge(ctx context.Context) (int, error) {
+	cutoff := j.now().Add(-j.retention)
+
+	total := 0
+	for {
+		n, err := j.store.Purge(ctx, cutoff, j.batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < j.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// PurgeIdempotencyKeys removes batches of expired idempotency keys until none
+// remain or an error occurs, and returns the number of keys removed
+func (j *Job) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := j.store.PurgeIdempotencyKeys(ctx, j.batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < j.batchSize {
+			return total, nil
+		}
+	}
+}
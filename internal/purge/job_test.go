@@ -0,0 +1,92 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/caring/go-packages/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStore is a slice backed Store holding the deleted_at of each soft deleted row
+// and a count of expired idempotency keys
+type fakeStore struct {
+	deleted []time.Time
+	keys    int
+	err     error
+	cutoffs []time.Time
+}
+
+func (f *fakeStore) PurgeIdempotencyKeys(ctx context.Context, limit int) (int, error) {
+	n := f.keys
+	if n > limit {
+		n = limit
+	}
+	f.keys -= n
+	return n, nil
+}
+
+func (f *fakeStore) Purge(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	f.cutoffs = append(f.cutoffs, deletedBefore)
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	kept := []time.Time{}
+	n := 0
+	for _, d := range f.deleted {
+		if n < limit && d.Before(deletedBefore) {
+			n++
+			continue
+		}
+		kept = append(kept, d)
+	}
+	f.deleted = kept
+	return n, nil
+}
+
+func TestJob_Purge(t *testing.T) {
+	now := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	retention := 24 * time.Hour
+
+	// ensures every expired row is removed across batches and recent ones are kept
+	t.Run("Expired rows", func(t *testing.T) {
+		store := &fakeStore{deleted: []time.Time{
+			now.Add(-72 * time.Hour),
+			now.Add(-48 * time.Hour),
+			now.Add(-25 * time.Hour),
+			now.Add(-time.Hour),
+		}}
+		j := NewJob(store, &logging.Logger{}, retention, 0, 2)
+		j.now = func() time.Time { return now }
+
+		n, err := j.Purge(context.Background())
+		assert.NoError(t, err, "Expected no error")
+		assert.Equal(t, 3, n, "Expected every expired row to be purged")
+		assert.Len(t, store.deleted, 1, "Expected the recent row to be kept")
+		assert.Equal(t, now.Add(-retention), store.cutoffs[0], "Expected the cutoff to trail now by the retention")
+	})
+
+	// ensures a store error stops the purge
+	t.Run("Store error", func(t *testing.T) {
+		store := &fakeStore{err: errors.New("boom")}
+		j := NewJob(store, &logging.Logger{}, retention, 0, 2)
+
+		_, err := j.Purge(context.Background())
+		assert.EqualError(t, err, "boom", "Expected the store error")
+		assert.Len(t, store.cutoffs, 1, "Expected no further batches")
+	})
+}
+
+func TestJob_PurgeIdempotencyKeys(t *testing.T) {
+	// ensures every expired key is removed across batches
+	store := &fakeStore{keys: 5}
+	j := NewJob(store, &logging.Logger{}, time.Hour, 0, 2)
+
+	n, err := j.PurgeIdempotencyKeys(context.Background())
+	assert.NoError(t, err, "Expected no error")
+	assert.Equal(t, 5, n, "Expected every expired key to be purged")
+	assert.Zero(t, store.keys, "Expected no keys to remain")
+}
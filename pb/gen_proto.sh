@@ -2,11 +2,17 @@
 if [ -f "$(command -v protoc)" ]; then
     VER=$(protoc --version)
     PBDIR="ford-thunderbird/pb/"
+    # google/api/annotations.proto and http.proto, from a checkout of
+    # https://github.com/googleapis/googleapis
+    GOOGLEAPIS_DIR=${GOOGLEAPIS_DIR:-"ford-thunderbird/third_party/googleapis/"}
     echo "Using protoc version: $VER"
     protoc \
       --proto_path=$PBDIR \
+      --proto_path=$GOOGLEAPIS_DIR \
       --go_out=plugins=grpc:$PBDIR \
-      --go_opt=paths=source_relative $PBDIR*.proto
+      --go_opt=paths=source_relative \
+      --grpc-gateway_out=$PBDIR \
+      --grpc-gateway_opt=paths=source_relative $PBDIR*.proto
 else
     echo "Error: protoc was not found. Please check that it is installed."
 fi
\ No newline at end of file
@@ -57,12 +57,6 @@ data "aws_secretsmanager_secret" "sentry_dsn" {
     aws_secretsmanager_secret.sentry_dsn_string
   ]
 }
-data "aws_secretsmanager_secret" "db_migration_src" {
-  name       = "${local.service_name}_db_migration_src"
-  depends_on = [
-    aws_secretsmanager_secret.db_migration_src_string
-  ]
-}
 
 data template_file "task_definition" {
   template = file("${path.module}/templates/task-definition.json")
@@ -101,13 +95,15 @@ data template_file "task_definition" {
     ####################
     # DB
     ####################
-    db_host           = module.rds_db.rds_instance_address
-    db_port           = "3306"
-    db_user           = local.service_name
-    db_pwd            = data.aws_secretsmanager_secret.rds_db_pass.arn
-    db_schema         = local.service_name
-    db_migrations_src = data.aws_secretsmanager_secret.db_migration_src.arn
-    waitfordbhost     = module.rds_db.rds_instance_address
+    db_host                 = module.rds_db.rds_instance_address
+    db_port                 = "3306"
+    db_user                 = local.service_name
+    db_pwd                  = data.aws_secretsmanager_secret.rds_db_pass.arn
+    db_schema               = local.service_name
+    db_reader_host          = var.db_reader_host[ terraform.workspace ]
+    db_slow_query_threshold = var.db_slow_query_threshold[ terraform.workspace ]
+    db_lazy_reprepare       = var.db_lazy_reprepare[ terraform.workspace ]
+    waitfordbhost           = module.rds_db.rds_instance_address
 
     #########################
     # Logging (Application)
@@ -136,5 +132,17 @@ data template_file "task_definition" {
     sentry_dsn     = data.aws_secretsmanager_secret.sentry_dsn.arn
     sentry_env     = local.env_name
     sentry_disable = var.sentry_disable[ terraform.workspace ]
+
+    #################
+    # outbox
+    #################
+    outbox_disable = var.outbox_disable[ terraform.workspace ]
+    outbox_stream  = module.firehose.firehose_reporting_stream_name
+
+    #################
+    # purge
+    #################
+    purge_disable   = var.purge_disable[ terraform.workspace ]
+    purge_retention = var.purge_retention[ terraform.workspace ]
   }
 }
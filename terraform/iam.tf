@@ -33,7 +33,6 @@ data "aws_iam_policy_document" "ecs_task_role" {
     resources = [
       
       "arn:aws:secretsmanager:${var.aws_region}:${data.aws_caller_identity.current.account_id}:secret:${local.service_name}_rds_db_pass-??????",
-      "arn:aws:secretsmanager:${var.aws_region}:${data.aws_caller_identity.current.account_id}:secret:${local.service_name}_db_migration_src-??????",
       "arn:aws:secretsmanager:${var.aws_region}:${data.aws_caller_identity.current.account_id}:secret:${local.service_name}_sentry_dsn-??????"
       
     ]
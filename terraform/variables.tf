@@ -180,3 +180,62 @@ variable "rds_disk_size" {
   default     = 20
 }
 
+variable "outbox_disable" {
+  description = "If set to TRUE, outbox events will not be published to the reporting firehose, they are still marked published and purged"
+  type        = map(string)
+  default     = {
+    caring-dev : "FALSE",
+    caring-stg : "FALSE",
+    caring-prod : "FALSE"
+  }
+}
+
+variable "purge_disable" {
+  description = "If set to TRUE, soft deleted thunderbirds and expired idempotency keys will never be permanently removed"
+  type        = map(string)
+  default     = {
+    caring-dev : "FALSE",
+    caring-stg : "FALSE",
+    caring-prod : "FALSE"
+  }
+}
+
+variable "purge_retention" {
+  description = "How long a soft deleted thunderbird is kept before it is permanently removed, as a go duration"
+  type        = map(string)
+  default     = {
+    caring-dev : "168h",
+    caring-stg : "168h",
+    caring-prod : "2160h"
+  }
+}
+
+variable "db_reader_host" {
+  description = "Host of a read replica of the RDS instance that reads made outside of a transaction are sent to, empty to send every read to the primary"
+  type        = map(string)
+  default     = {
+    caring-dev : "",
+    caring-stg : "",
+    caring-prod : ""
+  }
+}
+
+variable "db_slow_query_threshold" {
+  description = "Statements that take longer than this go duration are logged with their args redacted, 0 to log none"
+  type        = map(string)
+  default     = {
+    caring-dev : "100ms",
+    caring-stg : "250ms",
+    caring-prod : "250ms"
+  }
+}
+
+variable "db_lazy_reprepare" {
+  description = "If set to TRUE, statements the database no longer has prepared, e.g. after a failover, are prepared again and the operation retried"
+  type        = map(string)
+  default     = {
+    caring-dev : "TRUE",
+    caring-stg : "TRUE",
+    caring-prod : "TRUE"
+  }
+}